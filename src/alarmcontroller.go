@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AlarmController handles the Web Methods for listing and acknowledging the door open alarms.
+type AlarmController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *AlarmController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/alarms").Name("GetAlarms").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetAlarms))))
+	router.Methods("POST").Path("/alarms/{id}/ack").Name("AckAlarm").
+		Handler(Authorize(c, s, RoleOperator, Logger(c, http.HandlerFunc(c.handleAckAlarm))))
+}
+
+// handleGetAlarms returns the alarms of the doors that are currently open
+func (c *AlarmController) handleGetAlarms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.Srv.NotifyService.ActiveDoorAlarms())
+}
+
+// handleAckAlarm acknowledges the alarm, suppressing further reminders while the door remains open.
+// The optional snooze form value (in minutes) only suppresses the reminders for that period.
+func (c *AlarmController) handleAckAlarm(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id := mux.Vars(r)["id"]
+
+	var snooze time.Duration
+	if v := r.Form.Get("snooze"); v != "" {
+		m, err := strconv.Atoi(v)
+		if err != nil || m <= 0 {
+			http.Error(w, "Invalid snooze period", http.StatusBadRequest)
+			return
+		}
+		snooze = time.Duration(m) * time.Minute
+	}
+
+	a, err := c.Srv.NotifyService.Acknowledge(id, snooze)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if snooze > 0 {
+		c.LogInfo("Alarm ", id, " snoozed for ", snooze, " by ", r.RemoteAddr)
+	} else {
+		c.LogInfo("Alarm ", id, " acknowledged by ", r.RemoteAddr)
+	}
+	writeJSON(w, a)
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *AlarmController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("AlarmController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *AlarmController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("AlarmController: [Err] ", a)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// AlarmRuleConfig holds a time-of-day rule that changes how alarms are raised and notified
+type AlarmRuleConfig struct {
+	Name            string   `json:"name"`            // Name of the rule, e.g. night or weekend
+	Days            []string `json:"days"`            // Days the rule applies to (mon, tue, ..., sun, weekday or weekend), empty for every day
+	Start           string   `json:"start"`           // Local time the rule starts (HH:MM), empty for the start of the day
+	End             string   `json:"end"`             // Local time the rule ends (HH:MM), may be before the start to span midnight
+	DoorAlarmPeriod *int     `json:"doorAlarmPeriod"` // Overrides the time (in minutes) a door can be open before the alarm is raised
+	Quiet           bool     `json:"quiet"`           // Suppress notifications that are not alarms, e.g. door is now closed (auto-closes and guest access are always sent)
+}
+
+// Matches returns whether the rule applies at the specified local time. The days of a rule
+// that spans midnight refer to the day the rule started.
+func (r *AlarmRuleConfig) Matches(t time.Time) bool {
+	start, ok := parseClock(r.Start, 0)
+	if !ok {
+		return false
+	}
+	end, ok := parseClock(r.End, 24*time.Hour)
+	if !ok {
+		return false
+	}
+	now := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()))
+
+	day := t.Weekday()
+	if start <= end {
+		if now < start || now >= end {
+			return false
+		}
+	} else {
+		switch {
+		case now >= start:
+		case now < end:
+			day = (day + 6) % 7
+		default:
+			return false
+		}
+	}
+	return r.matchesDay(day)
+}
+
+// matchesDay returns whether the rule applies to the week day
+func (r *AlarmRuleConfig) matchesDay(d time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	weekend := d == time.Saturday || d == time.Sunday
+	name := strings.ToLower(d.String())
+	for _, v := range r.Days {
+		v = strings.ToLower(v)
+		if (v == "weekend" && weekend) || (v == "weekday" && !weekend) || (len(v) >= 3 && strings.HasPrefix(name, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses a HH:MM time of day into the offset from midnight. An empty value returns the default.
+func parseClock(s string, def time.Duration) (time.Duration, bool) {
+	if s == "" {
+		return def, true
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+}
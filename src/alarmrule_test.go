@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ruleTime returns the time on the specified day of January 2026 (Thursday the 1st) in UTC
+func ruleTime(day int, hour int, min int) time.Time {
+	return time.Date(2026, 1, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestAlarmRuleMatches(t *testing.T) {
+	friNight := AlarmRuleConfig{Days: []string{"fri"}, Start: "22:00", End: "06:00"}
+	weekendNight := AlarmRuleConfig{Days: []string{"weekend"}, Start: "22:00", End: "06:00"}
+	weekend := AlarmRuleConfig{Days: []string{"weekend"}}
+	workHours := AlarmRuleConfig{Days: []string{"weekday"}, Start: "08:00", End: "17:00"}
+	everyNight := AlarmRuleConfig{Start: "22:00", End: "06:00"}
+
+	tests := []struct {
+		name string
+		rule AlarmRuleConfig
+		at   time.Time
+		want bool
+	}{
+		{"night start", friNight, ruleTime(2, 22, 0), true},
+		{"before night", friNight, ruleTime(2, 21, 59), false},
+		{"night before midnight", friNight, ruleTime(2, 23, 30), true},
+		{"night after midnight", friNight, ruleTime(3, 2, 0), true},
+		{"night end", friNight, ruleTime(3, 6, 0), false},
+		{"night of the previous day", friNight, ruleTime(2, 2, 0), false},
+		{"saturday night", friNight, ruleTime(3, 23, 0), false},
+		{"every night", everyNight, ruleTime(5, 3, 0), true},
+		{"every day", everyNight, ruleTime(5, 12, 0), false},
+		{"sunday night into monday", weekendNight, ruleTime(5, 1, 0), true},
+		{"friday night into saturday", weekendNight, ruleTime(3, 1, 0), false},
+		{"saturday", weekend, ruleTime(3, 12, 0), true},
+		{"end of sunday", weekend, ruleTime(4, 23, 59), true},
+		{"start of monday", weekend, ruleTime(5, 0, 0), false},
+		{"end of friday", weekend, ruleTime(2, 23, 59), false},
+		{"work start", workHours, ruleTime(5, 8, 0), true},
+		{"work end", workHours, ruleTime(5, 17, 0), false},
+		{"saturday work", workHours, ruleTime(3, 10, 0), false},
+		{"full day name", AlarmRuleConfig{Days: []string{"Monday"}}, ruleTime(5, 10, 0), true},
+		{"short day name", AlarmRuleConfig{Days: []string{"mo"}}, ruleTime(5, 10, 0), false},
+		{"invalid start", AlarmRuleConfig{Start: "25:00"}, ruleTime(5, 10, 0), false},
+		{"invalid end", AlarmRuleConfig{End: "noon"}, ruleTime(5, 10, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Matches(tt.at); got != tt.want {
+				t.Errorf("%s: got %v, want %v", tt.at.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigAlarmRulesAt(t *testing.T) {
+	night := 1
+	c := Config{
+		DoorAlarmPeriod: 5,
+		Timezone:        "Africa/Johannesburg",
+		AlarmRules: []AlarmRuleConfig{
+			{Name: "night", Start: "22:00", End: "06:00", DoorAlarmPeriod: &night},
+			{Name: "weekend", Days: []string{"weekend"}, Quiet: true},
+		},
+	}
+
+	// Johannesburg is two hours ahead of UTC
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantPeriod int
+		wantQuiet  bool
+	}{
+		{"friday evening", ruleTime(2, 19, 59), 5, false},
+		{"friday night", ruleTime(2, 20, 0), 1, false},
+		{"saturday morning", ruleTime(3, 3, 59), 1, true},
+		{"saturday day", ruleTime(3, 4, 0), 5, true},
+		{"monday", ruleTime(4, 22, 0), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.DoorAlarmPeriodAt(tt.at); got != tt.wantPeriod {
+				t.Errorf("got period %d, want %d", got, tt.wantPeriod)
+			}
+			if got := c.IsQuietAt(tt.at); got != tt.wantQuiet {
+				t.Errorf("got quiet %v, want %v", got, tt.wantQuiet)
+			}
+			if got := c.IsRuleActiveAt("night", tt.at); got != (tt.wantPeriod == 1) {
+				t.Errorf("got night active %v, want %v", got, tt.wantPeriod == 1)
+			}
+		})
+	}
+}
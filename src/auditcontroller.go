@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AuditController handles the Web Methods for viewing, exporting and verifying the audit log.
+type AuditController struct {
+	Srv *Server
+}
+
+// AuditVerification holds the result of verifying the audit log hash chain
+type AuditVerification struct {
+	Valid   bool   `json:"valid"`           // Whether every entry is intact and in sequence
+	Entries int    `json:"entries"`         // Number of entries verified before any break
+	Error   string `json:"error,omitempty"` // Where the chain is broken
+}
+
+// AddController adds the controller routes to the router
+func (c *AuditController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/audit").Name("GetAudit").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleGetAudit))))
+	router.Methods("GET").Path("/audit/verify").Name("VerifyAudit").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleVerifyAudit))))
+}
+
+// handleGetAudit returns the audit entries, oldest first, filtered by the door, from and to
+// query parameters. The limit query parameter returns only the most recent entries. The entries
+// are returned as CSV if format=csv, as JSON lines if format=jsonl, otherwise as a JSON array.
+func (c *AuditController) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	if c.Srv.Audit == nil {
+		http.Error(w, "Audit log is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := HistoryQuery{}
+	v := r.URL.Query()
+	var err error
+	if d := v.Get("door"); d != "" {
+		if q.DoorNo, err = strconv.Atoi(d); err != nil {
+			http.Error(w, "Invalid door number", http.StatusBadRequest)
+			return
+		}
+	}
+	if q.From, err = parseTime(v.Get("from")); err != nil {
+		http.Error(w, "Invalid from time. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseTime(v.Get("to")); err != nil {
+		http.Error(w, "Invalid to time. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil || q.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	lst, err := c.Srv.Audit.Query(q)
+	if err != nil {
+		c.LogError("Error reading audit log. ", err.Error())
+		http.Error(w, "Error reading audit log", http.StatusInternalServerError)
+		return
+	}
+
+	switch v.Get("format") {
+	case "csv":
+		w.Header().Set("content-type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"seq", "time", "door", "action", "source", "remoteAddr", "principal", "result", "prevHash", "hash"})
+		for _, e := range lst {
+			cw.Write([]string{
+				strconv.FormatUint(e.Seq, 10),
+				e.Time.Format(time.RFC3339Nano),
+				strconv.Itoa(e.DoorNo),
+				e.Action,
+				e.Source,
+				e.RemoteAddr,
+				e.Principal,
+				e.Result,
+				e.PrevHash,
+				e.Hash,
+			})
+		}
+		cw.Flush()
+	case "jsonl":
+		w.Header().Set("content-type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		enc := json.NewEncoder(w)
+		for _, e := range lst {
+			enc.Encode(e)
+		}
+	default:
+		writeJSON(w, lst)
+	}
+}
+
+// handleVerifyAudit verifies the hash chain of the audit log
+func (c *AuditController) handleVerifyAudit(w http.ResponseWriter, r *http.Request) {
+	if c.Srv.Audit == nil {
+		http.Error(w, "Audit log is not available", http.StatusServiceUnavailable)
+		return
+	}
+	n, err := c.Srv.Audit.Verify()
+	res := AuditVerification{Valid: err == nil, Entries: n}
+	if err != nil {
+		c.LogError("Audit log verification failed. ", err.Error())
+		res.Error = err.Error()
+	}
+	writeJSON(w, res)
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *AuditController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("AuditController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *AuditController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("AuditController: [Err] ", a)
+}
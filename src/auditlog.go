@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a door actuation recorded in the audit log. Each entry holds the hash of
+// the entry before it, so that any change to, or removal of, an entry breaks the chain.
+type AuditEntry struct {
+	Seq        uint64    `json:"seq"`                  // Sequence number, starting at 1
+	Time       time.Time `json:"time"`                 // Time the command completed
+	DoorNo     int       `json:"door"`                 // Door number
+	Action     string    `json:"action"`               // Action: open, close or toggle
+	Source     string    `json:"source"`               // Channel the command arrived on, or external for doors moved outside this service
+	RemoteAddr string    `json:"remoteAddr,omitempty"` // Address of the caller
+	Principal  string    `json:"principal,omitempty"`  // Authenticated caller
+	Result     string    `json:"result"`               // ok, or the reason the command failed
+	PrevHash   string    `json:"prevHash"`             // Hash of the previous entry
+	Hash       string    `json:"hash"`                 // Hash of this entry
+}
+
+// computeHash returns the hash of the entry, calculated over all fields except the hash
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// AuditLog records the door actuations in an append-only, hash chained file
+type AuditLog struct {
+	Srv      *Server           // Server instance
+	Path     string            // Path to the log file
+	f        *os.File          // Log file, opened for appending
+	seq      uint64            // Sequence number of the last entry
+	lastHash string            // Hash of the last entry
+	lastCmd  map[int]time.Time // Time of the last command, by door number
+	mu       sync.Mutex        // Serializes the writes
+}
+
+// Open opens, creating if required, the audit log and verifies the hash chain
+func (a *AuditLog) Open() error {
+	if a.Path == "" {
+		a.Path = filepath.Join("data", "audit.log")
+	}
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return err
+	}
+
+	n, err := a.Verify()
+	if err != nil {
+		a.logError("Audit log verification failed. ", err.Error())
+	}
+	a.logInfo("Audit log opened with ", n, " entries")
+
+	f, err := os.OpenFile(a.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	a.f = f
+	a.lastCmd = make(map[int]time.Time)
+	return nil
+}
+
+// Close closes the audit log
+func (a *AuditLog) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil {
+		a.f.Close()
+		a.f = nil
+	}
+}
+
+// Start subscribes to the door changes so that doors moved outside this service, e.g. by a
+// wall button or remote, are recorded as external actuations
+func (a *AuditLog) Start() {
+	sub := a.Srv.State.Subscribe()
+	go func() {
+		last := map[int]bool{}
+		for e := range sub.C {
+			if e.Type != EventDoor || !e.Door.Enabled || e.Door.State == DoorUnknown {
+				continue
+			}
+			d := *e.Door
+			was, ok := last[d.ID]
+			last[d.ID] = d.Closed
+			if !ok || was == d.Closed {
+				continue
+			}
+			a.mu.Lock()
+			cmd := a.lastCmd[d.ID]
+			a.mu.Unlock()
+			travel := 30 * time.Second
+			if dc := a.Srv.Config.Door(d.ID); dc != nil {
+				travel = time.Duration(dc.TravelTimeout) * time.Second
+			}
+			if time.Since(cmd) <= travel+doorStartGrace {
+				continue
+			}
+			action := "open"
+			if d.Closed {
+				action = "close"
+			}
+			a.Record(d.ID, action, CommandOrigin{Source: SourceExternal}, nil)
+		}
+	}()
+}
+
+// Record appends the door actuation to the audit log
+func (a *AuditLog) Record(doorNo int, action string, o CommandOrigin, cmdErr error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if o.Source != SourceExternal {
+		a.lastCmd[doorNo] = time.Now()
+	}
+	if a.f == nil {
+		return errors.New("audit log is not open")
+	}
+
+	e := AuditEntry{
+		Seq:        a.seq + 1,
+		Time:       time.Now().UTC(),
+		DoorNo:     doorNo,
+		Action:     action,
+		Source:     o.Source,
+		RemoteAddr: o.RemoteAddr,
+		Principal:  o.Principal,
+		Result:     "ok",
+		PrevHash:   a.lastHash,
+	}
+	if cmdErr != nil {
+		e.Result = cmdErr.Error()
+	}
+	e.Hash = e.computeHash()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		a.logError("Error writing audit entry. ", err.Error())
+		return err
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+	a.seq = e.Seq
+	a.lastHash = e.Hash
+	return nil
+}
+
+// Query returns the entries matching the query, oldest first
+func (a *AuditLog) Query(q HistoryQuery) ([]AuditEntry, error) {
+	lst := []AuditEntry{}
+	err := a.scan(func(e AuditEntry) error {
+		if q.DoorNo != 0 && e.DoorNo != q.DoorNo {
+			return nil
+		}
+		if e.Time.Before(q.From) || (!q.To.IsZero() && e.Time.After(q.To)) {
+			return nil
+		}
+		lst = append(lst, e)
+		return nil
+	})
+	if q.Limit > 0 && len(lst) > q.Limit {
+		lst = lst[len(lst)-q.Limit:]
+	}
+	return lst, err
+}
+
+// Verify checks the hash chain of the audit log. Returns the number of entries verified.
+func (a *AuditLog) Verify() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := 0
+	prev := ""
+	var seq uint64
+	var verr error
+	err := a.scan(func(e AuditEntry) error {
+		if verr == nil {
+			switch {
+			case e.Seq != seq+1:
+				verr = fmt.Errorf("entry %d follows entry %d", e.Seq, seq)
+			case e.PrevHash != prev:
+				verr = fmt.Errorf("entry %d does not follow the previous entry", e.Seq)
+			case e.computeHash() != e.Hash:
+				verr = fmt.Errorf("entry %d has been altered", e.Seq)
+			default:
+				n++
+			}
+		}
+		seq = e.Seq
+		prev = e.Hash
+		return nil
+	})
+	// New entries always follow the last entry in the file, so that a break stays detectable
+	a.seq = seq
+	a.lastHash = prev
+	if err != nil {
+		return n, err
+	}
+	return n, verr
+}
+
+// scan calls the function for each entry in the log file
+func (a *AuditLog) scan(fn func(e AuditEntry) error) error {
+	f, err := os.Open(a.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		e := AuditEntry{}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("invalid entry on line %d. %s", line, err.Error())
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// logInfo logs an information message to the logger
+func (a *AuditLog) logInfo(v ...interface{}) {
+	m := fmt.Sprint(v...)
+	logger.Info("AuditLog: [Inf] ", m)
+}
+
+// logError logs an error message to the logger
+func (a *AuditLog) logError(v ...interface{}) {
+	m := fmt.Sprint(v...)
+	logger.Error("AuditLog: [Err] ", m)
+}
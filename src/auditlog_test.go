@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestAuditLog records three door actuations in a new audit log and returns its lines
+func writeTestAuditLog(t *testing.T, path string) []string {
+	a := &AuditLog{Path: path}
+	if err := a.Open(); err != nil {
+		t.Fatal(err)
+	}
+	a.Record(1, "open", CommandOrigin{Source: SourceRest, RemoteAddr: "10.0.0.2:5000", Principal: "phone"}, nil)
+	a.Record(2, "close", CommandOrigin{Source: SourceMqtt}, errors.New("door did not close"))
+	a.Record(1, "close", CommandOrigin{Source: SourceSchedule}, nil)
+	a.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestAuditLogVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		tamper  func(lines []string) []string
+		wantN   int
+		wantErr bool
+	}{
+		{"intact", func(l []string) []string { return l }, 3, false},
+		{"altered", func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"door did not close"`, `"ok"`, 1)
+			return l
+		}, 1, true},
+		{"altered and rehashed", func(l []string) []string {
+			l[1] = strings.Replace(l[1], `"door did not close"`, `"ok"`, 1)
+			e := parseAuditLine(t, l[1])
+			e.Hash = e.computeHash()
+			l[1] = auditLine(t, e)
+			return l
+		}, 2, true},
+		{"removed", func(l []string) []string { return append(l[:1], l[2:]...) }, 1, true},
+		{"reordered", func(l []string) []string { return []string{l[0], l[2], l[1]} }, 1, true},
+		{"inserted", func(l []string) []string {
+			e := parseAuditLine(t, l[0])
+			e.Seq = 2
+			e.PrevHash = e.Hash
+			e.Hash = e.computeHash()
+			return []string{l[0], auditLine(t, e), l[1], l[2]}
+		}, 2, true},
+		{"invalid entry", func(l []string) []string { return []string{l[0], "{", l[1], l[2]} }, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, strings.Replace(tt.name, " ", "-", -1)+".log")
+			lines := tt.tamper(writeTestAuditLog(t, p))
+			if err := ioutil.WriteFile(p, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			a := &AuditLog{Path: p}
+			n, err := a.Verify()
+			if n != tt.wantN || (err != nil) != tt.wantErr {
+				t.Errorf("got %d entries and error %v, want %d entries and error %v", n, err, tt.wantN, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuditLogBreakStaysDetectable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "audit.log")
+	lines := writeTestAuditLog(t, p)
+	lines = append(lines[:1], lines[2:]...)
+	if err := ioutil.WriteFile(p, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries appended after the break must not hide it
+	a := &AuditLog{Path: p}
+	if err := a.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Record(1, "open", CommandOrigin{Source: SourceRest}, nil); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	if _, err := a.Verify(); err == nil {
+		t.Error("break in the chain not detected after appending")
+	}
+}
+
+// parseAuditLine parses a line of the audit log
+func parseAuditLine(t *testing.T, line string) AuditEntry {
+	e := AuditEntry{}
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// auditLine returns the audit log line of the entry
+func auditLine(t *testing.T, e AuditEntry) string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role is the level of access granted to a principal
+type Role string
+
+// Roles, each role is granted the access of the roles before it
+const (
+	RoleViewer   Role = "viewer"   // Read the telemetry, history and reports
+	RoleOperator Role = "operator" // Send door commands and acknowledge alarms
+	RoleAdmin    Role = "admin"    // Change the configuration and read the logs
+)
+
+// level returns the rank of the role, 0 for an unknown role
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Allows returns whether the role is granted the access of the required role
+func (r Role) Allows(required Role) bool {
+	return r.level() != 0 && r.level() >= required.level()
+}
+
+// AuthConfig holds the API tokens and users allowed to use the web methods
+type AuthConfig struct {
+	Enabled        bool             `json:"enabled"`        // Require authentication, all requests are granted the anonymous role when disabled
+	AnonymousRole  Role             `json:"anonymousRole"`  // Role granted to all requests when authentication is disabled (defaults to viewer, door commands need operator)
+	Tokens         []APITokenConfig `json:"tokens"`         // API tokens
+	Users          []UserConfig     `json:"users"`          // Basic authentication users, used for the web pages
+	ClientCertRole Role             `json:"clientCertRole"` // Role granted to callers presenting a verified TLS client certificate (empty to not use client certificates)
+}
+
+// APITokenConfig holds an API token. Only the hash of the token is stored.
+type APITokenConfig struct {
+	Name string `json:"name"` // Name identifying the token holder
+	Hash string `json:"hash"` // Hex encoded SHA-256 hash of the token
+	Role Role   `json:"role"` // Role granted to the token
+}
+
+// UserConfig holds a basic authentication user. Only the bcrypt hash of the password is stored.
+type UserConfig struct {
+	Username     string `json:"username"`     // Username
+	PasswordHash string `json:"passwordHash"` // bcrypt hash of the password
+	Role         Role   `json:"role"`         // Role granted to the user
+}
+
+// Principal identifies the caller of a web method
+type Principal struct {
+	Name string `json:"name"` // Token name or username
+	Role Role   `json:"role"` // Role granted to the caller
+}
+
+// anonymousName is the name of the principal used when authentication is disabled
+const anonymousName = "anonymous"
+
+// localName is the name of the principal used for callers on the loopback interface
+const localName = "local"
+
+// principalKey is the request context key of the authenticated principal
+type principalKey struct{}
+
+// SetDefaults sets the default anonymous role
+func (a *AuthConfig) SetDefaults() {
+	if a.AnonymousRole.level() == 0 {
+		a.AnonymousRole = RoleViewer
+	}
+}
+
+// HashToken returns the hash of an API token as stored in the configuration
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// HashPassword returns the hash of a password as stored in the configuration
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+// Authenticate returns the principal of the request. API tokens are accepted as a bearer token,
+// in the X-API-Key header or in the access_token query value (for EventSource and WebSocket
+// clients that cannot set headers). Returns false if the credentials are missing or invalid.
+func (a *AuthConfig) Authenticate(r *http.Request) (Principal, bool) {
+	if !a.Enabled {
+		return Principal{Name: anonymousName, Role: a.AnonymousRole}, true
+	}
+
+	if a.ClientCertRole != "" && r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		return Principal{Name: r.TLS.VerifiedChains[0][0].Subject.CommonName, Role: a.ClientCertRole}, true
+	}
+
+	if u, p, ok := r.BasicAuth(); ok {
+		for _, uc := range a.Users {
+			if uc.Username == u && bcrypt.CompareHashAndPassword([]byte(uc.PasswordHash), []byte(p)) == nil {
+				return Principal{Name: uc.Username, Role: uc.Role}, true
+			}
+		}
+		return Principal{}, false
+	}
+
+	t := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		t = strings.TrimPrefix(h, "Bearer ")
+	}
+	if t == "" {
+		t = r.URL.Query().Get("access_token")
+	}
+	if t == "" {
+		return Principal{}, false
+	}
+	h := HashToken(t)
+	for _, tc := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(strings.ToLower(tc.Hash))) == 1 {
+			return Principal{Name: tc.Name, Role: tc.Role}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// Authorize will create a handler wrapper that only calls the handler if the caller is
+// authenticated and granted the required role. Refused requests are logged.
+func Authorize(c Controller, s *Server, required Role, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := s.Config.Auth.Authenticate(r)
+		if !ok {
+			c.LogInfo("Unauthenticated ", r.Method, " ", r.URL.Path, " from ", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="Garage"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.Role.Allows(required) {
+			c.LogInfo("Forbidden ", r.Method, " ", r.URL.Path, " from ", p.Name, " (", p.Role, ") at ", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// AuthorizeLocal will create a handler wrapper that calls the handler for callers on the
+// loopback interface without credentials, such as the python script monitoring the door
+// switches, and otherwise requires the required role as Authorize does.
+func AuthorizeLocal(c Controller, s *Server, required Role, inner http.Handler) http.Handler {
+	auth := Authorize(c, s, required, inner)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLoopback(r.RemoteAddr) {
+			p := Principal{Name: localName, Role: required}
+			inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+			return
+		}
+		auth.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback returns whether the remote address is on the loopback interface
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// RequestPrincipal returns the principal authenticated for the request
+func RequestPrincipal(r *http.Request) Principal {
+	if p, ok := r.Context().Value(principalKey{}).(Principal); ok {
+		return p
+	}
+	return Principal{}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleAdmin, RoleOperator, true},
+		{"", RoleViewer, false},
+		{"owner", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q allows %q: got %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+// newTestAuthConfig returns an enabled configuration with an operator token and a viewer user
+func newTestAuthConfig(t *testing.T) *AuthConfig {
+	h, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AuthConfig{
+		Enabled: true,
+		Tokens:  []APITokenConfig{{Name: "phone", Hash: strings.ToUpper(HashToken("s3cret")), Role: RoleOperator}},
+		Users:   []UserConfig{{Username: "alice", PasswordHash: h, Role: RoleViewer}},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newTestAuthConfig(t)
+	tests := []struct {
+		name     string
+		setup    func(r *http.Request)
+		path     string
+		wantName string
+		wantOK   bool
+	}{
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, "/", "phone", true},
+		{"api key header", func(r *http.Request) { r.Header.Set("X-API-Key", "s3cret") }, "/", "phone", true},
+		{"query token", func(r *http.Request) {}, "/?access_token=s3cret", "phone", true},
+		{"invalid token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, "/", "", false},
+		{"basic auth", func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }, "/", "alice", true},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("alice", "hunter3") }, "/", "", false},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("bob", "hunter2") }, "/", "", false},
+		{"wrong password with token", func(r *http.Request) {
+			r.SetBasicAuth("alice", "hunter3")
+			r.Header.Set("X-API-Key", "s3cret")
+		}, "/", "", false},
+		{"no credentials", func(r *http.Request) {}, "/", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			tt.setup(r)
+			p, ok := a.Authenticate(r)
+			if ok != tt.wantOK || p.Name != tt.wantName {
+				t.Errorf("got %q %v, want %q %v", p.Name, ok, tt.wantName, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAuthenticateClientCert(t *testing.T) {
+	chain := [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "car"}}}}
+	tests := []struct {
+		name     string
+		role     Role
+		state    *tls.ConnectionState
+		wantName string
+		wantOK   bool
+	}{
+		{"verified certificate", RoleOperator, &tls.ConnectionState{VerifiedChains: chain}, "car", true},
+		{"client certificates not used", "", &tls.ConnectionState{VerifiedChains: chain}, "", false},
+		{"no certificate", RoleOperator, &tls.ConnectionState{}, "", false},
+		{"plain HTTP", RoleOperator, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AuthConfig{Enabled: true, ClientCertRole: tt.role}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tt.state
+			p, ok := a.Authenticate(r)
+			if ok != tt.wantOK || p.Name != tt.wantName || (ok && p.Role != tt.role) {
+				t.Errorf("got %+v %v, want %q %v", p, ok, tt.wantName, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   AuthConfig
+		wantRole Role
+	}{
+		{"default", AuthConfig{}, RoleViewer},
+		{"operator", AuthConfig{AnonymousRole: RoleOperator}, RoleOperator},
+		{"unknown role", AuthConfig{AnonymousRole: "owner"}, RoleViewer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.SetDefaults()
+			p, ok := tt.config.Authenticate(httptest.NewRequest("GET", "/", nil))
+			if !ok || p.Name != anonymousName || p.Role != tt.wantRole {
+				t.Errorf("got %+v %v, want the anonymous %s", p, ok, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s := &Server{Config: &Config{Auth: *newTestAuthConfig(t)}}
+	var got Principal
+	h := Authorize(&RoomController{}, s, RoleOperator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestPrincipal(r)
+	}))
+
+	tests := []struct {
+		name       string
+		setup      func(r *http.Request)
+		wantStatus int
+		wantName   string
+	}{
+		{"operator", func(r *http.Request) { r.Header.Set("X-API-Key", "s3cret") }, http.StatusOK, "phone"},
+		{"viewer", func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }, http.StatusForbidden, ""},
+		{"unauthenticated", func(r *http.Request) {}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = Principal{}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/room/door/1/open", nil)
+			tt.setup(r)
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus || got.Name != tt.wantName {
+				t.Errorf("got status %d for %q, want %d for %q", w.Code, got.Name, tt.wantStatus, tt.wantName)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no authentication challenge")
+			}
+		})
+	}
+}
+
+func TestAuthorizeLocal(t *testing.T) {
+	s := &Server{Config: &Config{Auth: *newTestAuthConfig(t)}}
+	var got Principal
+	h := AuthorizeLocal(&RoomController{}, s, RoleViewer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestPrincipal(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		setup      func(r *http.Request)
+		wantStatus int
+		wantName   string
+	}{
+		{"loopback", "127.0.0.1:40000", func(r *http.Request) {}, http.StatusOK, localName},
+		{"loopback ipv6", "[::1]:40000", func(r *http.Request) {}, http.StatusOK, localName},
+		{"remote viewer", "10.0.0.2:40000", func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }, http.StatusOK, "alice"},
+		{"remote unauthenticated", "10.0.0.2:40000", func(r *http.Request) {}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = Principal{}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/room/update", nil)
+			r.RemoteAddr = tt.remoteAddr
+			tt.setup(r)
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus || got.Name != tt.wantName {
+				t.Errorf("got status %d for %q, want %d for %q", w.Code, got.Name, tt.wantStatus, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNoAutoClose is returned when cancelling an auto-close that is not pending
+var ErrNoAutoClose = errors.New("no auto-close is pending for the door")
+
+// AutoCloseConfig holds the auto-close policy of a door
+type AutoCloseConfig struct {
+	Enabled bool   `json:"enabled"` // Enable auto-close
+	After   int    `json:"after"`   // Period of time (in minutes) the door can be open before it is closed
+	Warning int    `json:"warning"` // Period of time (in minutes) the warning is sent before the door is closed (defaults to 2)
+	Rule    string `json:"rule"`    // Only auto-close while the named alarm rule applies, e.g. night (empty for any time)
+}
+
+// autoCloseState holds the auto-close state of a single open episode of a door
+type autoCloseState struct {
+	Opened    time.Time // Time the door was opened
+	WarnedAt  time.Time // Time the warning was sent, zero if it has not been sent
+	Cancelled bool      // Whether the auto-close was cancelled for this episode
+	Closing   bool      // Whether the door is being closed
+}
+
+// AutoCloser closes doors that have been left open according to their auto-close policy
+type AutoCloser struct {
+	Srv    *Server                 // Server instance
+	states map[int]*autoCloseState // Auto-close state by door number
+	mu     sync.Mutex              // Protects the auto-close state
+}
+
+// Run is called from the scheduler (ClockWerk). This function warns about, and then closes,
+// the doors that have been open for longer than their auto-close period.
+func (a *AutoCloser) Run() {
+	// Send the warnings once the lock is released, so a slow notifier does not block Cancel
+	for _, p := range a.check(time.Now()) {
+		a.notify(p.Event, p.Message)
+	}
+}
+
+// check updates the auto-close state of the doors and starts closing the doors that are due.
+// Returns the warnings to send.
+func (a *AutoCloser) check(now time.Time) []pendingNotice {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.states == nil {
+		a.states = make(map[int]*autoCloseState)
+	}
+
+	var msgs []pendingNotice
+	for _, d := range a.Srv.State.Snapshot().Doors {
+		dc := a.Srv.Config.Door(d.ID)
+		if dc == nil || !d.Enabled || !dc.AutoClose.Enabled || dc.AutoClose.After <= 0 || d.Closed {
+			delete(a.states, d.ID)
+			continue
+		}
+
+		st := a.states[d.ID]
+		if st == nil || !st.Opened.Equal(d.StatusTime) {
+			st = &autoCloseState{Opened: d.StatusTime}
+			a.states[d.ID] = st
+		}
+		if st.Cancelled || st.Closing || d.State.IsMoving() {
+			continue
+		}
+		if dc.AutoClose.Rule != "" && !a.Srv.Config.IsRuleActiveAt(dc.AutoClose.Rule, now) {
+			st.WarnedAt = time.Time{}
+			continue
+		}
+
+		warning := time.Duration(dc.AutoClose.Warning) * time.Minute
+		if dc.AutoClose.Warning <= 0 {
+			warning = 2 * time.Minute
+		}
+		closeAt := d.StatusTime.Add(time.Duration(dc.AutoClose.After) * time.Minute)
+
+		if st.WarnedAt.IsZero() {
+			if now.Before(closeAt.Add(-warning)) {
+				continue
+			}
+			st.WarnedAt = now
+			a.logInfo("Door", d.ID, " will be closed in ", warning)
+			msgs = append(msgs, pendingNotice{Event: NotifyAutoCloseWarning, Message: fmt.Sprintf("%s's door will be closed automatically in %d minutes. Cancel with POST /room/door/%d/autoclose/cancel or MQTT %s/autoclose CANCEL.",
+				d.Name, int(warning.Minutes()), d.ID, a.Srv.MqttClient.doorTopic(d.ID))})
+			continue
+		}
+		// Always give the full warning period, even if the door has been open for longer
+		if now.Before(closeAt) || now.Before(st.WarnedAt.Add(warning)) {
+			continue
+		}
+
+		st.Closing = true
+		go a.closeDoor(d.ID, d.Name)
+	}
+	return msgs
+}
+
+// Cancel cancels the pending auto-close of the door for the rest of its open episode
+func (a *AutoCloser) Cancel(doorNo int, o CommandOrigin) error {
+	a.mu.Lock()
+	st, ok := a.states[doorNo]
+	if !ok || st.Closing || st.Cancelled {
+		a.mu.Unlock()
+		return ErrNoAutoClose
+	}
+	st.Cancelled = true
+	warned := !st.WarnedAt.IsZero()
+	a.mu.Unlock()
+
+	a.logInfo("Auto-close of door", doorNo, " cancelled from ", o.Source, " ", o.RemoteAddr)
+	if warned {
+		if dc := a.Srv.Config.Door(doorNo); dc != nil {
+			a.notify(NotifyAutoCloseCancelled, fmt.Sprintf("Auto-close of %s's door was cancelled.", dc.Name))
+		}
+	}
+	return nil
+}
+
+// closeDoor closes the door and notifies the outcome
+func (a *AutoCloser) closeDoor(doorNo int, name string) {
+	a.logInfo("Auto-closing door", doorNo)
+	err := a.Srv.RoomService.CloseDoor(doorNo, CommandOrigin{Source: SourceAutoClose})
+	switch {
+	case err == nil, errors.Is(err, ErrDoorAlreadyClosed):
+		a.notify(NotifyAutoClosed, fmt.Sprintf("%s's door was closed automatically.", name))
+	default:
+		a.logError("Auto-close of door", doorNo, " failed. ", err.Error())
+		if err := a.Srv.NotifyService.Alarm(NotifyAutoCloseFailed, doorNo, fmt.Sprintf("%s's door could not be closed automatically. %s.", name, err.Error())); err != nil {
+			a.logError("Error notifying that door ", doorNo, " could not be closed. ", err.Error())
+		}
+	}
+
+	a.mu.Lock()
+	if st, ok := a.states[doorNo]; ok {
+		st.Closing = false
+		// Do not retry during this open episode
+		st.Cancelled = err != nil
+	}
+	a.mu.Unlock()
+}
+
+// notify sends the auto-close message
+func (a *AutoCloser) notify(event string, m string) {
+	if err := a.Srv.NotifyService.Notify(event, m); err != nil {
+		a.logError("Error sending ", event, " notification. ", err.Error())
+	}
+}
+
+// logInfo logs an information message to the logger
+func (a *AutoCloser) logInfo(v ...interface{}) {
+	m := fmt.Sprint(v...)
+	logger.Info("AutoCloser: [Inf] ", m)
+}
+
+// logError logs an error message to the logger
+func (a *AutoCloser) logError(v ...interface{}) {
+	m := fmt.Sprint(v...)
+	logger.Error("AutoCloser: [Err] ", m)
+}
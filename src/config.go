@@ -2,30 +2,91 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 )
 
 // Config holds the configuration required for the Service
 type Config struct {
-	EnableDoor1      bool   `json:"enableDoor1"`      // Enable door 1
-	Door1Name        string `json:"door1Name"`        // The name of door 1
-	EnableDoor2      bool   `json:"enableDoor2"`      // Enable door 2
-	Door2Name        string `json:"door2Name"`        // The name of door 2
-	Period           int    `json:"period"`           // Cloud update period (in minutes)
-	EnableThingspeak bool   `json:"enableThingspeak"` // Enable Thingspeak integration
-	ThingspeakID     string `json:"thingspeakID"`     // Thingspeak ID
-	EnableMqtt       bool   `json:"enableMqtt"`       // Enable MQTT integration
-	MqttHost         string `json:"mqttHost"`         // MQTT Host
-	MqttUsername     string `json:"mqttUsername"`     // MQTT Username
-	MqttPassword     string `json:"mqttPassword"`     // MQTT password
-	EnableDoorAlarm  bool   `json:"enableDoorAlarm"`  // Enable Door Alarms
-	DoorAlarmPeriod  int    `json:"doorAlarmPeriod"`  // Max period of time (in minutes) a door can be open after which an alarm is sent every minute
-}
-
-// ReadFromFile will read the configuration settings from the specified file
+	Doors               []DoorConfig       `json:"doors"`               // The doors being controlled
+	TempSensors         []TempSensorConfig `json:"tempSensors"`         // Named one-wire temperature sensors, the first is the room temperature
+	Period              int                `json:"period"`              // Cloud update period (in minutes)
+	EnableThingspeak    bool               `json:"enableThingspeak"`    // Enable Thingspeak integration
+	ThingspeakID        string             `json:"thingspeakID"`        // Thingspeak ID
+	ThingspeakPingField int                `json:"thingspeakPingField"` // Thingspeak field number the heartbeat (always 1) is uploaded to (defaults to 3, -1 to not upload)
+	ThingspeakTempField int                `json:"thingspeakTempField"` // Thingspeak field number the room temperature is uploaded to (defaults to 4, -1 to not upload)
+	EnableMqtt          bool               `json:"enableMqtt"`          // Enable MQTT integration
+	MqttHost            string             `json:"mqttHost"`            // MQTT Host
+	MqttUsername        string             `json:"mqttUsername"`        // MQTT Username
+	MqttPassword        string             `json:"mqttPassword"`        // MQTT password
+	EnableDoorAlarm     bool               `json:"enableDoorAlarm"`     // Enable Door Alarms
+	DoorAlarmPeriod     int                `json:"doorAlarmPeriod"`     // Max period of time (in minutes) a door can be open after which an alarm is sent every minute
+	DoorAlarmReminders  []int              `json:"doorAlarmReminders"`  // Back-off (in minutes) between the reminders of a door left open, the last value repeats
+	DoorAlarmEscalation int                `json:"doorAlarmEscalation"` // Period of time (in minutes) a door can be open after which the alarm is escalated (0 to not escalate)
+	AlarmRules          []AlarmRuleConfig  `json:"alarmRules"`          // Time-of-day rules, e.g. night mode, quiet hours or weekend thresholds
+	Timezone            string             `json:"timezone"`            // IANA time zone the alarm rules and reports use, e.g. Africa/Johannesburg (defaults to the system time zone)
+	Schedules           []ScheduleConfig   `json:"schedules"`           // User-defined scheduled actions
+	Auth                AuthConfig         `json:"auth"`                // API tokens, users and roles
+	TLS                 TLSConfig          `json:"tls"`                 // HTTPS settings
+	CommandRateLimit    int                `json:"commandRateLimit"`    // Maximum number of door commands each client can send per minute (defaults to 10, -1 for no limit)
+	Notifiers           []NotifierConfig   `json:"notifiers"`           // Notification channels, Telegram is used if none are configured
+	EnableTempAlarm     bool               `json:"enableTempAlarm"`     // Enable temperature threshold alarms
+	HistoryRetention    int                `json:"historyRetention"`    // Number of days the door event history is kept for
+	SensorStalePeriod   int                `json:"sensorStalePeriod"`   // Period of time (in minutes) without a valid reading after which a temperature sensor is stale
+}
+
+// DoorConfig holds the configuration for a single door
+type DoorConfig struct {
+	ID               int             `json:"id"`               // Door number, used in the web methods, MQTT topics and state file names
+	Name             string          `json:"name"`             // The name of the door
+	Enabled          bool            `json:"enabled"`          // Enable the door
+	ThingspeakField  int             `json:"thingspeakField"`  // Thingspeak field number the door state is uploaded to (0 to not upload)
+	Sensor           SensorConfig    `json:"sensor"`           // Door closed sensor
+	OpenSensor       *SensorConfig   `json:"openSensor"`       // Optional door fully open sensor
+	Relay            RelayConfig     `json:"relay"`            // Door opener relay
+	TravelTimeout    int             `json:"travelTimeout"`    // Max time (in seconds) the door takes to open or close
+	MinPulseInterval int             `json:"minPulseInterval"` // Minimum time (in seconds) between relay pulses (defaults to 3)
+	AutoClose        AutoCloseConfig `json:"autoClose"`        // Close the door automatically when left open
+}
+
+// TempSensorConfig maps a one-wire temperature sensor to a name
+type TempSensorConfig struct {
+	ID                string          `json:"id"`                // One-wire device ID
+	Name              string          `json:"name"`              // Name, used as the key in the room, MQTT topics and history
+	ThingspeakField   int             `json:"thingspeakField"`   // Thingspeak field number the temperature is uploaded to (0 to not upload)
+	Offset            float64         `json:"offset"`            // Calibration offset added to the scaled reading
+	Scale             float64         `json:"scale"`             // Calibration multiplier (0 for no scaling)
+	MinTemp           float64         `json:"minTemp"`           // Lowest valid reading (defaults to -55)
+	MaxTemp           float64         `json:"maxTemp"`           // Highest valid reading (defaults to 125)
+	MaxDeltaPerMinute float64         `json:"maxDeltaPerMinute"` // Readings changing faster than this per minute are rejected as spikes (defaults to 10)
+	Average           int             `json:"average"`           // Number of readings in the moving average (0 for no averaging)
+	HighAlarm         TempAlarmConfig `json:"highAlarm"`         // Too hot alarm
+	LowAlarm          TempAlarmConfig `json:"lowAlarm"`          // Too cold alarm
+}
+
+// TempAlarmConfig holds the settings of a temperature threshold alarm
+type TempAlarmConfig struct {
+	Enabled    bool    `json:"enabled"`    // Enable the alarm
+	Threshold  float64 `json:"threshold"`  // Temperature beyond which the alarm is raised
+	Hysteresis float64 `json:"hysteresis"` // Amount the temperature must return past the threshold before the alarm clears
+	Duration   int     `json:"duration"`   // Period of time (in minutes) the threshold must be exceeded before the alarm is raised
+}
+
+// legacyConfig holds the door settings used by configuration files
+// written before multiple doors were supported
+type legacyConfig struct {
+	EnableDoor1 bool   `json:"enableDoor1"` // Enable door 1
+	Door1Name   string `json:"door1Name"`   // The name of door 1
+	EnableDoor2 bool   `json:"enableDoor2"` // Enable door 2
+	Door2Name   string `json:"door2Name"`   // The name of door 2
+}
+
+// ReadFromFile will read the configuration settings from the specified file.
+// Configuration files using the old two door format are migrated and written back.
 func (c *Config) ReadFromFile(path string) error {
 	_, err := os.Stat(path)
 	if !os.IsNotExist(err) {
@@ -33,6 +94,9 @@ func (c *Config) ReadFromFile(path string) error {
 		if err == nil {
 			err = json.Unmarshal(b, &c)
 		}
+		if err == nil && c.migrate(b) {
+			err = c.WriteToFile(path)
+		}
 	}
 	c.SetDefaults()
 	return err
@@ -44,7 +108,11 @@ func (c *Config) WriteToFile(path string) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, b, 0666)
+	// The file holds the token and password hashes and the notifier secrets
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
 }
 
 // ReadFrom reads the string from the reader and deserializes it into the config values
@@ -53,6 +121,9 @@ func (c *Config) ReadFrom(r io.ReadCloser) error {
 	if err == nil {
 		if b != nil && len(b) != 0 {
 			err = json.Unmarshal(b, &c)
+			if err == nil {
+				c.migrate(b)
+			}
 		}
 	}
 	c.SetDefaults()
@@ -70,8 +141,151 @@ func (c *Config) WriteTo(w http.ResponseWriter) error {
 	return nil
 }
 
+// Location returns the configured time zone, or the system time zone if it is not configured or invalid
+func (c *Config) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	l, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return l
+}
+
+// DoorAlarmPeriodAt returns the time (in minutes) a door can be open before the alarm is
+// raised at the specified time. The first matching rule that overrides the period is used.
+func (c *Config) DoorAlarmPeriodAt(t time.Time) int {
+	t = t.In(c.Location())
+	for i := range c.AlarmRules {
+		r := &c.AlarmRules[i]
+		if r.DoorAlarmPeriod != nil && r.Matches(t) {
+			return *r.DoorAlarmPeriod
+		}
+	}
+	return c.DoorAlarmPeriod
+}
+
+// IsRuleActiveAt returns whether the named alarm rule applies at the specified time
+func (c *Config) IsRuleActiveAt(name string, t time.Time) bool {
+	t = t.In(c.Location())
+	for i := range c.AlarmRules {
+		r := &c.AlarmRules[i]
+		if r.Name == name && r.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsQuietAt returns whether the notifications that are not alarms are suppressed at the specified time
+func (c *Config) IsQuietAt(t time.Time) bool {
+	t = t.In(c.Location())
+	for i := range c.AlarmRules {
+		r := &c.AlarmRules[i]
+		if r.Quiet && r.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
 // SetDefaults checks the configuration and makes sure that, if
 // a value is not configured, the default value is set.
 func (c *Config) SetDefaults() {
 	// Set default values, if required
+	if c.HistoryRetention <= 0 {
+		c.HistoryRetention = 90
+	}
+	c.Auth.SetDefaults()
+	c.TLS.SetDefaults()
+	if c.DoorAlarmPeriod <= 0 {
+		c.DoorAlarmPeriod = 5
+	}
+	if len(c.DoorAlarmReminders) == 0 {
+		c.DoorAlarmReminders = []int{5, 15, 30, 60}
+	}
+	if c.CommandRateLimit == 0 {
+		c.CommandRateLimit = 10
+	}
+	if c.SensorStalePeriod <= 0 {
+		c.SensorStalePeriod = 15
+	}
+	if c.ThingspeakPingField == 0 {
+		c.ThingspeakPingField = 3
+	}
+	if c.ThingspeakTempField == 0 {
+		c.ThingspeakTempField = 4
+	}
+	// Doors without a number, or with the number of an earlier door, are given the first free
+	// number from their position in the list
+	used := make(map[int]bool)
+	numbered := make([]bool, len(c.Doors))
+	for i, d := range c.Doors {
+		if d.ID > 0 && !used[d.ID] {
+			used[d.ID] = true
+			numbered[i] = true
+		}
+	}
+	for i := range c.Doors {
+		d := &c.Doors[i]
+		if !numbered[i] {
+			d.ID = i + 1
+			for used[d.ID] {
+				d.ID++
+			}
+			used[d.ID] = true
+		}
+		if d.Name == "" {
+			d.Name = fmt.Sprintf("Door %d", d.ID)
+		}
+		if d.TravelTimeout <= 0 {
+			d.TravelTimeout = 30
+		}
+		if d.MinPulseInterval <= 0 {
+			d.MinPulseInterval = 3
+		}
+	}
+}
+
+// Door returns the configuration for the door with the specified number.
+// Nil is returned if the door does not exist.
+func (c *Config) Door(id int) *DoorConfig {
+	for i := range c.Doors {
+		if c.Doors[i].ID == id {
+			return &c.Doors[i]
+		}
+	}
+	return nil
+}
+
+// TempSensor returns the configuration for the temperature sensor with the specified
+// one-wire device ID. Nil is returned if the sensor has not been named.
+func (c *Config) TempSensor(id string) *TempSensorConfig {
+	for i := range c.TempSensors {
+		if c.TempSensors[i].ID == id {
+			return &c.TempSensors[i]
+		}
+	}
+	return nil
+}
+
+// migrate converts the door settings from the old two door format into the
+// list of doors. Returns true if the settings were migrated.
+func (c *Config) migrate(b []byte) bool {
+	if len(c.Doors) != 0 {
+		return false
+	}
+	l := legacyConfig{}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return false
+	}
+	if !l.EnableDoor1 && !l.EnableDoor2 && l.Door1Name == "" && l.Door2Name == "" {
+		return false
+	}
+	c.Doors = []DoorConfig{
+		{ID: 1, Name: l.Door1Name, Enabled: l.EnableDoor1, ThingspeakField: 1},
+		{ID: 2, Name: l.Door2Name, Enabled: l.EnableDoor2, ThingspeakField: 2},
+	}
+	return true
 }
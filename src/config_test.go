@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigDoorIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []int
+		want []int
+	}{
+		{"numbered", []int{1, 2}, []int{1, 2}},
+		{"unnumbered", []int{0, 0}, []int{1, 2}},
+		{"reversed", []int{2, 1}, []int{2, 1}},
+		{"unnumbered after numbered", []int{2, 0}, []int{2, 3}},
+		{"unnumbered before numbered", []int{0, 1}, []int{2, 1}},
+		{"duplicate", []int{2, 2}, []int{2, 3}},
+		{"duplicate of a later door", []int{0, 3, 3, 0}, []int{1, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			for _, id := range tt.ids {
+				c.Doors = append(c.Doors, DoorConfig{ID: id})
+			}
+			c.SetDefaults()
+			got := []int{}
+			for _, d := range c.Doors {
+				got = append(got, d.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got door numbers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
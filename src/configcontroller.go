@@ -24,11 +24,11 @@ type ConfigPageData struct {
 // AddController adds the controller routes to the router
 func (c *ConfigController) AddController(router *mux.Router, s *Server) {
 	c.Srv = s
-	router.Path("/config.html").Handler(http.HandlerFunc(c.handleConfigWebPage))
+	router.Path("/config.html").Handler(Authorize(c, s, RoleAdmin, http.HandlerFunc(c.handleConfigWebPage)))
 	router.Methods("GET").Path("/config/get").Name("GetConfig").
-		Handler(Logger(c, http.HandlerFunc(c.handleGetConfig)))
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleGetConfig))))
 	router.Methods("POST").Path("/config/set").Name("SetConfig").
-		Handler(Logger(c, http.HandlerFunc(c.handleSetConfig)))
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleSetConfig))))
 }
 
 func (c *ConfigController) handleConfigWebPage(w http.ResponseWriter, r *http.Request) {
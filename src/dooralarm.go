@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+// DoorAlarm holds the state of the open alarm for a single open episode of a door
+type DoorAlarm struct {
+	ID        string    `json:"id"`        // Alarm ID, used to acknowledge the alarm
+	DoorNo    int       `json:"door"`      // Door number
+	Opened    time.Time `json:"opened"`    // Time the door was opened
+	Notices   int       `json:"notices"`   // Number of notices sent
+	Next      time.Time `json:"next"`      // Time the next notice is due
+	Escalated bool      `json:"escalated"` // Whether the alarm has been escalated
+	Snoozed   bool      `json:"snoozed"`   // Whether the alarm has been snoozed until the next notice
+	Acked     bool      `json:"acked"`     // Whether the alarm has been acknowledged
+}
+
+// reminderDelay returns the delay before the next reminder after the specified number of
+// notices. The last back-off period is repeated once the list is exhausted.
+func reminderDelay(backoff []int, notices int) time.Duration {
+	if len(backoff) == 0 {
+		return time.Minute
+	}
+	i := notices - 1
+	if i >= len(backoff) {
+		i = len(backoff) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return time.Duration(backoff[i]) * time.Minute
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DoorSensor defines an interface for a sensor that detects whether a door is closed
+type DoorSensor interface {
+	IsClosed() (bool, error) // Returns whether the door is closed
+	Close() error            // Releases any resources held by the sensor
+}
+
+// WatchableSensor defines an interface for a DoorSensor that can report changes
+// to the door state as they happen
+type WatchableSensor interface {
+	DoorSensor
+	Watch(onChange func()) error // Calls onChange whenever the sensor changes state
+}
+
+// SensorConfig holds the configuration for a door sensor
+type SensorConfig struct {
+	Type       string `json:"type"`       // Sensor type: file (default), gpio or fake
+	Path       string `json:"path"`       // State file path (file sensors), defaults to data/doorN.state
+	Chip       string `json:"chip"`       // GPIO chip, e.g. gpiochip0 (gpio sensors)
+	Line       int    `json:"line"`       // GPIO line offset (gpio sensors)
+	ActiveLow  bool   `json:"activeLow"`  // Switch is closed when the line is low (gpio sensors)
+	PullUp     bool   `json:"pullUp"`     // Enable the internal pull-up resistor (gpio sensors)
+	DebounceMs int    `json:"debounceMs"` // Switch debounce period in milliseconds (gpio sensors)
+}
+
+// NewDoorSensor creates the door sensor described by the configuration for the specified door
+func NewDoorSensor(doorNo int, c SensorConfig) (DoorSensor, error) {
+	switch c.Type {
+	case "", "file":
+		p := c.Path
+		if p == "" {
+			p = path.Join("data", fmt.Sprintf("door%d.state", doorNo))
+		}
+		return &FileDoorSensor{Path: p}, nil
+	case "gpio":
+		return NewGpioDoorSensor(GpioLineConfig{
+			Chip:       c.Chip,
+			Line:       c.Line,
+			ActiveLow:  c.ActiveLow,
+			PullUp:     c.PullUp,
+			Edges:      true,
+			DebounceUs: uint32(c.DebounceMs) * 1000,
+			Consumer:   fmt.Sprintf("garage-door%d", doorNo),
+		})
+	case "fake":
+		return &FakeDoorSensor{Closed: true}, nil
+	}
+	return nil, fmt.Errorf("unknown sensor type '%s'", c.Type)
+}
+
+// FileDoorSensor reads the door state from a file written by an external process
+type FileDoorSensor struct {
+	Path string // Path to the state file
+}
+
+// IsClosed returns whether the state file reports the door as closed
+func (s *FileDoorSensor) IsClosed() (bool, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(b), "closed"), nil
+}
+
+// Close releases any resources held by the sensor
+func (s *FileDoorSensor) Close() error {
+	return nil
+}
+
+// GpioDoorSensor reads the door state from a reed switch connected to a GPIO line.
+// The switch is considered closed when the line is active.
+type GpioDoorSensor struct {
+	line *gpioLine
+}
+
+// NewGpioDoorSensor requests the GPIO line for the reed switch
+func NewGpioDoorSensor(c GpioLineConfig) (*GpioDoorSensor, error) {
+	c.Output = false
+	l, err := requestGpioLine(c)
+	if err != nil {
+		return nil, err
+	}
+	return &GpioDoorSensor{line: l}, nil
+}
+
+// IsClosed returns whether the reed switch is closed
+func (s *GpioDoorSensor) IsClosed() (bool, error) {
+	return s.line.Value()
+}
+
+// Watch calls onChange whenever the reed switch changes state, until the sensor is closed
+func (s *GpioDoorSensor) Watch(onChange func()) error {
+	go func() {
+		for {
+			if err := s.line.WaitEvent(); err != nil {
+				return
+			}
+			onChange()
+		}
+	}()
+	return nil
+}
+
+// Close releases the GPIO line
+func (s *GpioDoorSensor) Close() error {
+	return s.line.Close()
+}
+
+// FakeDoorSensor is an in-memory door sensor used for testing
+type FakeDoorSensor struct {
+	Closed   bool   // Whether the door is closed
+	Err      error  // Error to return when reading the sensor
+	onChange func() // Change callback
+	mu       sync.Mutex
+}
+
+// IsClosed returns the current fake door state
+func (s *FakeDoorSensor) IsClosed() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Closed, s.Err
+}
+
+// Set changes the fake door state and notifies the watcher
+func (s *FakeDoorSensor) Set(closed bool) {
+	s.mu.Lock()
+	s.Closed = closed
+	f := s.onChange
+	s.mu.Unlock()
+	if f != nil {
+		f()
+	}
+}
+
+// Watch calls onChange whenever Set is called
+func (s *FakeDoorSensor) Watch(onChange func()) error {
+	if onChange == nil {
+		return errors.New("no change function specified")
+	}
+	s.mu.Lock()
+	s.onChange = onChange
+	s.mu.Unlock()
+	return nil
+}
+
+// Close releases any resources held by the sensor
+func (s *FakeDoorSensor) Close() error {
+	return nil
+}
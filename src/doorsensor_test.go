@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewDoorSensor(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   SensorConfig
+		wantPath string // Expected state file path of file sensors
+		wantFake bool   // Whether a fake sensor is expected
+		wantErr  bool
+	}{
+		{"default", SensorConfig{}, "data/door2.state", false, false},
+		{"file", SensorConfig{Type: "file", Path: "/tmp/garage.state"}, "/tmp/garage.state", false, false},
+		{"fake", SensorConfig{Type: "fake"}, "", true, false},
+		{"unknown", SensorConfig{Type: "laser"}, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewDoorSensor(2, tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch v := s.(type) {
+			case *FileDoorSensor:
+				if v.Path != tt.wantPath {
+					t.Errorf("got path %s, want %s", v.Path, tt.wantPath)
+				}
+			case *FakeDoorSensor:
+				if !tt.wantFake {
+					t.Error("got a fake sensor")
+				}
+			default:
+				t.Errorf("unexpected sensor type %T", s)
+			}
+		})
+	}
+}
+
+func TestFileDoorSensor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string // State file content, empty to not create the file
+		want    bool
+		wantErr bool
+	}{
+		{"closed", "closed\n", true, false},
+		{"open", "open\n", false, false},
+		{"missing", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name+".state")
+			if tt.content != "" {
+				if err := ioutil.WriteFile(p, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := FileDoorSensor{Path: p}
+			got, err := s.IsClosed()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got closed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeDoorSensorWatch(t *testing.T) {
+	s := &FakeDoorSensor{Closed: true}
+	changes := 0
+	if err := s.Watch(func() { changes++ }); err != nil {
+		t.Fatal(err)
+	}
+	s.Set(false)
+	if closed, _ := s.IsClosed(); closed || changes != 1 {
+		t.Errorf("got closed %v after %d changes, want open after 1", closed, changes)
+	}
+	if err := s.Watch(nil); err == nil {
+		t.Error("expected an error watching without a change function")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DoorStatus is the travel state of a door
+type DoorStatus string
+
+// Door travel states. These match the states understood by a Home Assistant cover.
+const (
+	DoorUnknown DoorStatus = "unknown" // The sensors could not be read or the position cannot be determined
+	DoorClosed  DoorStatus = "closed"  // The door is fully closed
+	DoorOpening DoorStatus = "opening" // The door is moving towards the open position
+	DoorOpen    DoorStatus = "open"    // The door is open
+	DoorClosing DoorStatus = "closing" // The door is moving towards the closed position
+	DoorStopped DoorStatus = "stopped" // The door stopped part way
+)
+
+// doorStartGrace is the time allowed after a relay pulse for the door to move off its limit switch
+const doorStartGrace = 5 * time.Second
+
+// doorMachine tracks the travel state of a single door from its limit switches
+// and the relay commands issued to it
+type doorMachine struct {
+	Travel        time.Duration // Time the door takes to fully open or close
+	HasOpenSensor bool          // Whether the door has an open-limit switch
+	status        DoorStatus    // Current state
+	since         time.Time     // Time the current state was entered
+	pulsed        bool          // Whether the current state was entered from a relay pulse
+	direction     DoorStatus    // Last direction of travel, opening or closing
+	mu            sync.Mutex
+}
+
+// newDoorMachine creates a door state machine in the unknown state
+func newDoorMachine(travel time.Duration, hasOpenSensor bool) *doorMachine {
+	return &doorMachine{
+		Travel:        travel,
+		HasOpenSensor: hasOpenSensor,
+		status:        DoorUnknown,
+		since:         time.Now(),
+		direction:     DoorClosing,
+	}
+}
+
+// Status returns the current state and the time it was entered
+func (m *doorMachine) Status() (DoorStatus, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status, m.since
+}
+
+// Pulse records that the relay was pulsed. Like most garage door openers, a pulse starts
+// a stationary door, stops a moving door and reverses a stopped door.
+func (m *doorMachine) Pulse(now time.Time) DoorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.status {
+	case DoorClosed:
+		m.set(DoorOpening, now, true)
+	case DoorOpen:
+		m.set(DoorClosing, now, true)
+	case DoorOpening, DoorClosing:
+		m.set(DoorStopped, now, true)
+	case DoorStopped:
+		if m.direction == DoorOpening {
+			m.set(DoorClosing, now, true)
+		} else {
+			m.set(DoorOpening, now, true)
+		}
+	}
+	return m.status
+}
+
+// PulsesTo returns the number of relay pulses needed to set the door moving in the
+// specified direction, opening or closing. Returns 1 if the state is unknown.
+func (m *doorMachine) PulsesTo(dir DoorStatus) int {
+	m.mu.Lock()
+	sim := &doorMachine{status: m.status, direction: m.direction}
+	m.mu.Unlock()
+	for n := 1; n <= 3; n++ {
+		if sim.Pulse(time.Time{}) == dir {
+			return n
+		}
+	}
+	return 1
+}
+
+// Update evaluates the limit switch readings and returns the new state.
+// isOpen is ignored if the door has no open-limit switch.
+func (m *doorMachine) Update(isClosed bool, isOpen bool, err error, now time.Time) DoorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elapsed := now.Sub(m.since)
+	switch {
+	case err != nil:
+		m.set(DoorUnknown, now, false)
+	case isClosed:
+		// Give the door time to leave the closed switch after a pulse
+		if !(m.status == DoorOpening && m.pulsed && elapsed < doorStartGrace) {
+			m.set(DoorClosed, now, false)
+		}
+	case m.HasOpenSensor && isOpen:
+		// Give the door time to leave the open switch after a pulse
+		if !(m.status == DoorClosing && m.pulsed && elapsed < doorStartGrace) {
+			m.set(DoorOpen, now, false)
+		}
+	default:
+		// The door is somewhere between the limit switches
+		switch m.status {
+		case DoorClosed:
+			m.set(DoorOpening, now, false)
+		case DoorOpen:
+			if m.HasOpenSensor {
+				m.set(DoorClosing, now, false)
+			}
+		case DoorOpening:
+			if elapsed > m.Travel {
+				if m.HasOpenSensor {
+					m.set(DoorStopped, now, false)
+				} else {
+					m.set(DoorOpen, now, false)
+				}
+			}
+		case DoorClosing:
+			if elapsed > m.Travel {
+				m.set(DoorStopped, now, false)
+			}
+		case DoorUnknown:
+			if m.HasOpenSensor {
+				m.set(DoorStopped, now, false)
+			} else {
+				m.set(DoorOpen, now, false)
+			}
+		}
+	}
+	return m.status
+}
+
+// set moves the machine to the specified state
+func (m *doorMachine) set(s DoorStatus, now time.Time, pulsed bool) {
+	if s == DoorOpening || s == DoorClosing {
+		m.direction = s
+	}
+	if s == m.status {
+		return
+	}
+	m.status = s
+	m.since = now
+	m.pulsed = pulsed
+}
+
+// IsMoving returns whether the status is a travelling state
+func (s DoorStatus) IsMoving() bool {
+	return s == DoorOpening || s == DoorClosing
+}
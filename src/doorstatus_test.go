@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// doorStep is a single relay pulse or sensor reading applied to a door state machine
+type doorStep struct {
+	At     time.Duration // Time of the step, from the start of the test
+	Pulse  bool          // Pulse the relay instead of reading the sensors
+	Closed bool          // Closed-limit switch reading
+	Open   bool          // Open-limit switch reading
+	Err    error         // Sensor error
+	Want   DoorStatus    // Expected state after the step
+}
+
+func TestDoorMachine(t *testing.T) {
+	errSensor := errors.New("sensor error")
+	tests := []struct {
+		name          string
+		hasOpenSensor bool
+		steps         []doorStep
+	}{
+		{"closed", false, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+		}},
+		{"opened without open sensor", false, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Want: DoorOpening},
+			{At: 20 * time.Second, Want: DoorOpening},
+			{At: 32 * time.Second, Want: DoorOpen},
+			{At: time.Minute, Closed: true, Want: DoorClosed},
+		}},
+		{"opened and closed with open sensor", true, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Want: DoorOpening},
+			{At: 20 * time.Second, Open: true, Want: DoorOpen},
+			{At: time.Minute, Want: DoorClosing},
+			{At: 80 * time.Second, Closed: true, Want: DoorClosed},
+		}},
+		{"stopped opening", true, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Want: DoorOpening},
+			{At: 32 * time.Second, Want: DoorStopped},
+		}},
+		{"stopped closing", true, []doorStep{
+			{At: 0, Open: true, Want: DoorOpen},
+			{At: time.Second, Want: DoorClosing},
+			{At: 32 * time.Second, Want: DoorStopped},
+		}},
+		{"sensor error", true, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Err: errSensor, Want: DoorUnknown},
+			{At: 2 * time.Second, Closed: true, Want: DoorClosed},
+		}},
+		{"unknown between switches", true, []doorStep{
+			{At: 0, Want: DoorStopped},
+		}},
+		{"unknown without open sensor", false, []doorStep{
+			{At: 0, Want: DoorOpen},
+		}},
+		{"pulse unknown", false, []doorStep{
+			{At: 0, Pulse: true, Want: DoorUnknown},
+		}},
+		{"pulse grace", false, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Pulse: true, Want: DoorOpening},
+			{At: 3 * time.Second, Closed: true, Want: DoorOpening},
+			{At: 4 * time.Second, Want: DoorOpening},
+		}},
+		{"pulse did not move the door", false, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Pulse: true, Want: DoorOpening},
+			{At: 7 * time.Second, Closed: true, Want: DoorClosed},
+		}},
+		{"pulse stops and reverses", true, []doorStep{
+			{At: 0, Closed: true, Want: DoorClosed},
+			{At: time.Second, Pulse: true, Want: DoorOpening},
+			{At: 5 * time.Second, Pulse: true, Want: DoorStopped},
+			{At: 10 * time.Second, Pulse: true, Want: DoorClosing},
+			{At: 15 * time.Second, Pulse: true, Want: DoorStopped},
+			{At: 20 * time.Second, Pulse: true, Want: DoorOpening},
+		}},
+		{"pulse closes an open door", true, []doorStep{
+			{At: 0, Open: true, Want: DoorOpen},
+			{At: time.Second, Pulse: true, Want: DoorClosing},
+			{At: 3 * time.Second, Open: true, Want: DoorClosing},
+			{At: 10 * time.Second, Want: DoorClosing},
+			{At: 20 * time.Second, Closed: true, Want: DoorClosed},
+		}},
+	}
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newDoorMachine(30*time.Second, tt.hasOpenSensor)
+			for i, s := range tt.steps {
+				now := start.Add(s.At)
+				var got DoorStatus
+				if s.Pulse {
+					got = m.Pulse(now)
+				} else {
+					got = m.Update(s.Closed, s.Open, s.Err, now)
+				}
+				if got != s.Want {
+					t.Fatalf("step %d: got %s, want %s", i, got, s.Want)
+				}
+			}
+		})
+	}
+}
+
+func TestDoorMachineStatusTime(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := newDoorMachine(30*time.Second, false)
+	m.Update(true, false, nil, start)
+	m.Update(true, false, nil, start.Add(time.Minute))
+	if s, since := m.Status(); s != DoorClosed || !since.Equal(start) {
+		t.Errorf("got %s since %v, want %s since %v", s, since, DoorClosed, start)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DoorWatcher watches the data directory for changes to the door state files
+// and updates the room as soon as a door state changes
+type DoorWatcher struct {
+	Srv      *Server           // Server instance
+	Dir      string            // Directory containing the door state files
+	Debounce time.Duration     // Time to wait for further changes before updating the room
+	OnChange func()            // Called once the state files have stopped changing, defaults to sending the telemetry
+	watcher  *fsnotify.Watcher // File system watcher
+	timer    *time.Timer       // Debounce timer
+	mu       sync.Mutex        // Protects the debounce timer
+}
+
+// Start starts watching the data directory for changes
+func (d *DoorWatcher) Start() error {
+	if d.Dir == "" {
+		d.Dir = "data"
+	}
+	if d.Debounce <= 0 {
+		d.Debounce = 250 * time.Millisecond
+	}
+	if d.OnChange == nil {
+		d.OnChange = d.Srv.SendTelemetry
+	}
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		d.logError("Error creating directory ", d.Dir, ". ", err.Error())
+		return err
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		d.logError("Error creating file watcher. ", err.Error())
+		return err
+	}
+	if err := w.Add(d.Dir); err != nil {
+		d.logError("Error watching directory ", d.Dir, ". ", err.Error())
+		w.Close()
+		return err
+	}
+	d.watcher = w
+
+	go d.watch()
+
+	d.logInfo("Watching ", d.Dir, " for door state changes")
+	return nil
+}
+
+// Close stops watching the data directory
+func (d *DoorWatcher) Close() {
+	if d.watcher != nil {
+		d.watcher.Close()
+	}
+	d.mu.Lock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.mu.Unlock()
+}
+
+// watch processes the file system events until the watcher is closed
+func (d *DoorWatcher) watch() {
+	for {
+		select {
+		case e, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			if !d.isStateFile(e.Name) || !e.Has(fsnotify.Write) && !e.Has(fsnotify.Create) {
+				continue
+			}
+			d.logDebug("Door state file changed. ", e.String())
+			d.schedule()
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			d.logError("Error watching door state files. ", err.Error())
+		}
+	}
+}
+
+// schedule updates the room once the state files have stopped changing. The
+// watcher script truncates and then writes the file, generating multiple events.
+func (d *DoorWatcher) schedule() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(d.Debounce, d.OnChange)
+}
+
+// isStateFile returns whether the specified file is a door state file
+func (d *DoorWatcher) isStateFile(name string) bool {
+	b := filepath.Base(name)
+	return strings.HasPrefix(b, "door") && strings.HasSuffix(b, ".state")
+}
+
+// logDebug logs a debug message to the logger
+func (d *DoorWatcher) logDebug(v ...interface{}) {
+	if d.Srv.VerboseLogging {
+		a := fmt.Sprint(v...)
+		logger.Info("DoorWatcher: [Dbg] ", a)
+	}
+}
+
+// logInfo logs an information message to the logger
+func (d *DoorWatcher) logInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("DoorWatcher: [Inf] ", a)
+}
+
+// logError logs an error message to the logger
+func (d *DoorWatcher) logError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("DoorWatcher: [Err] ", a)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDoorWatcherIsStateFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"data/door1.state", true},
+		{"/var/lib/garage/door12.state", true},
+		{"door1.state.tmp", false},
+		{"data/temp.state", false},
+		{"data/door1.log", false},
+	}
+	d := DoorWatcher{}
+	for _, tt := range tests {
+		if got := d.isStateFile(tt.name); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDoorWatcherDebounce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	changes := make(chan struct{}, 10)
+	d := &DoorWatcher{
+		Srv:      &Server{},
+		Dir:      dir,
+		Debounce: 100 * time.Millisecond,
+		OnChange: func() { changes <- struct{}{} },
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// Other files are ignored
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+		t.Fatal("change reported for a file that is not a door state file")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// The truncate and write of the watcher script are reported as a single change
+	p := filepath.Join(dir, "door1.state")
+	for _, s := range []string{"", "open", "", "closed"} {
+		if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("change not reported")
+	}
+	select {
+	case <-changes:
+		t.Error("change reported more than once")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,224 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux GPIO character device (v2 uAPI) definitions from linux/gpio.h
+const (
+	gpioV2LinesMax        = 64
+	gpioV2LineNumAttrsMax = 10
+	gpioMaxNameSize       = 32
+
+	gpioV2LineFlagActiveLow    = 1 << 1
+	gpioV2LineFlagInput        = 1 << 2
+	gpioV2LineFlagOutput       = 1 << 3
+	gpioV2LineFlagEdgeRising   = 1 << 4
+	gpioV2LineFlagEdgeFalling  = 1 << 5
+	gpioV2LineFlagBiasPullUp   = 1 << 8
+	gpioV2LineFlagBiasPullDown = 1 << 9
+
+	gpioV2LineAttrIDOutputValues = 2
+	gpioV2LineAttrIDDebounce     = 3
+
+	gpioV2LineEventSize = 48
+)
+
+type gpioV2LineAttribute struct {
+	ID      uint32
+	Padding uint32
+	Value   uint64 // flags, values or debounce_period_us
+}
+
+type gpioV2LineConfigAttribute struct {
+	Attr gpioV2LineAttribute
+	Mask uint64
+}
+
+type gpioV2LineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [gpioV2LineNumAttrsMax]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	Offsets         [gpioV2LinesMax]uint32
+	Consumer        [gpioMaxNameSize]byte
+	Config          gpioV2LineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	Fd              int32
+}
+
+type gpioV2LineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+var (
+	gpioV2GetLineIoctl       = gpioIOWR(0x07, unsafe.Sizeof(gpioV2LineRequest{}))
+	gpioV2LineGetValuesIoctl = gpioIOWR(0x0E, unsafe.Sizeof(gpioV2LineValues{}))
+	gpioV2LineSetValuesIoctl = gpioIOWR(0x0F, unsafe.Sizeof(gpioV2LineValues{}))
+)
+
+// gpioIOWR builds the ioctl request number for a read/write GPIO ioctl
+func gpioIOWR(nr uintptr, size uintptr) uintptr {
+	return (3 << 30) | (size << 16) | (0xB4 << 8) | nr
+}
+
+// GpioLineConfig holds the settings used to request a single GPIO line
+type GpioLineConfig struct {
+	Chip       string // GPIO chip device, e.g. gpiochip0 or /dev/gpiochip0
+	Line       int    // Line offset on the chip
+	ActiveLow  bool   // Line is active when low
+	PullUp     bool   // Enable the internal pull-up resistor
+	PullDown   bool   // Enable the internal pull-down resistor
+	Output     bool   // Request the line as an output
+	Edges      bool   // Report rising and falling edge events (inputs only)
+	DebounceUs uint32 // Debounce period in microseconds (inputs only)
+	Consumer   string // Consumer label shown by the kernel
+}
+
+// gpioLine is a single line requested from a GPIO character device
+type gpioLine struct {
+	f *os.File
+}
+
+// requestGpioLine requests the line described by the configuration from the GPIO chip
+func requestGpioLine(c GpioLineConfig) (*gpioLine, error) {
+	chip := c.Chip
+	if chip == "" {
+		chip = "gpiochip0"
+	}
+	if !strings.HasPrefix(chip, "/") {
+		chip = "/dev/" + chip
+	}
+	if c.Line < 0 {
+		return nil, fmt.Errorf("invalid line offset %d", c.Line)
+	}
+
+	cf, err := os.OpenFile(chip, os.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer cf.Close()
+
+	r := gpioV2LineRequest{NumLines: 1}
+	r.Offsets[0] = uint32(c.Line)
+	copy(r.Consumer[:gpioMaxNameSize-1], c.Consumer)
+	if c.Output {
+		r.Config.Flags = gpioV2LineFlagOutput
+		// Start with the line inactive
+		r.Config.Attrs[0] = gpioV2LineConfigAttribute{
+			Attr: gpioV2LineAttribute{ID: gpioV2LineAttrIDOutputValues, Value: 0},
+			Mask: 1,
+		}
+		r.Config.NumAttrs = 1
+	} else {
+		r.Config.Flags = gpioV2LineFlagInput
+		if c.Edges {
+			r.Config.Flags |= gpioV2LineFlagEdgeRising | gpioV2LineFlagEdgeFalling
+		}
+		if c.DebounceUs > 0 {
+			r.Config.Attrs[0] = gpioV2LineConfigAttribute{
+				Attr: gpioV2LineAttribute{ID: gpioV2LineAttrIDDebounce, Value: uint64(c.DebounceUs)},
+				Mask: 1,
+			}
+			r.Config.NumAttrs = 1
+		}
+	}
+	if c.ActiveLow {
+		r.Config.Flags |= gpioV2LineFlagActiveLow
+	}
+	if c.PullUp {
+		r.Config.Flags |= gpioV2LineFlagBiasPullUp
+	} else if c.PullDown {
+		r.Config.Flags |= gpioV2LineFlagBiasPullDown
+	}
+
+	if err := gpioIoctl(cf.Fd(), gpioV2GetLineIoctl, unsafe.Pointer(&r)); err != nil {
+		return nil, fmt.Errorf("requesting line %d on %s: %w", c.Line, chip, err)
+	}
+
+	// Make the line pollable so that blocking reads are released when the line is closed
+	if err := unix.SetNonblock(int(r.Fd), true); err != nil {
+		unix.Close(int(r.Fd))
+		return nil, err
+	}
+	return &gpioLine{f: os.NewFile(uintptr(r.Fd), fmt.Sprintf("%s:%d", chip, c.Line))}, nil
+}
+
+// Value returns whether the line is active
+func (l *gpioLine) Value() (bool, error) {
+	v := gpioV2LineValues{Mask: 1}
+	if err := l.ioctl(gpioV2LineGetValuesIoctl, unsafe.Pointer(&v)); err != nil {
+		return false, err
+	}
+	return v.Bits&1 == 1, nil
+}
+
+// SetValue sets the line active or inactive
+func (l *gpioLine) SetValue(active bool) error {
+	v := gpioV2LineValues{Mask: 1}
+	if active {
+		v.Bits = 1
+	}
+	return l.ioctl(gpioV2LineSetValuesIoctl, unsafe.Pointer(&v))
+}
+
+// WaitEvent blocks until an edge event is received on the line. An error is
+// returned if the line is closed.
+func (l *gpioLine) WaitEvent() error {
+	b := make([]byte, gpioV2LineEventSize)
+	n, err := l.f.Read(b)
+	if err != nil {
+		return err
+	}
+	if n != gpioV2LineEventSize {
+		return errors.New("short read of line event")
+	}
+	// Sanity check the event id (1 = rising, 2 = falling)
+	if id := binary.LittleEndian.Uint32(b[8:12]); id != 1 && id != 2 {
+		return fmt.Errorf("unexpected line event %d", id)
+	}
+	return nil
+}
+
+// Close releases the line
+func (l *gpioLine) Close() error {
+	return l.f.Close()
+}
+
+// ioctl performs the ioctl on the line file descriptor
+func (l *gpioLine) ioctl(req uintptr, arg unsafe.Pointer) error {
+	rc, err := l.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ierr error
+	if err := rc.Control(func(fd uintptr) {
+		ierr = gpioIoctl(fd, req, arg)
+	}); err != nil {
+		return err
+	}
+	return ierr
+}
+
+// gpioIoctl calls the ioctl system call
+func gpioIoctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
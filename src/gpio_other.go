@@ -0,0 +1,38 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// GpioLineConfig holds the settings used to request a single GPIO line
+type GpioLineConfig struct {
+	Chip       string // GPIO chip device, e.g. gpiochip0 or /dev/gpiochip0
+	Line       int    // Line offset on the chip
+	ActiveLow  bool   // Line is active when low
+	PullUp     bool   // Enable the internal pull-up resistor
+	PullDown   bool   // Enable the internal pull-down resistor
+	Output     bool   // Request the line as an output
+	Edges      bool   // Report rising and falling edge events (inputs only)
+	DebounceUs uint32 // Debounce period in microseconds (inputs only)
+	Consumer   string // Consumer label shown by the kernel
+}
+
+// gpioLine is a single line requested from a GPIO character device
+type gpioLine struct{}
+
+// requestGpioLine is not supported on this platform
+func requestGpioLine(c GpioLineConfig) (*gpioLine, error) {
+	return nil, errors.New("GPIO character devices are only supported on Linux")
+}
+
+// Value returns whether the line is active
+func (l *gpioLine) Value() (bool, error) { return false, errors.New("not supported") }
+
+// SetValue sets the line active or inactive
+func (l *gpioLine) SetValue(active bool) error { return errors.New("not supported") }
+
+// WaitEvent blocks until an edge event is received on the line
+func (l *gpioLine) WaitEvent() error { return errors.New("not supported") }
+
+// Close releases the line
+func (l *gpioLine) Close() error { return nil }
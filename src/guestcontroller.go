@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// guestPage is the mobile page guests use to open the doors their pass allows
+var guestPage = template.Must(template.New("guest").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Garage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; text-align: center; }
+button { display: block; width: 100%; margin: 1em 0; padding: 1.2em; font-size: 1.3em; }
+#status { min-height: 1.5em; }
+</style>
+</head>
+<body>
+<h2>Hello {{.Name}}</h2>
+<p>This pass is valid until {{.ValidTo}}.</p>
+{{range .Doors}}<button onclick="openDoor({{.ID}}, this)">Open {{.Name}}</button>
+{{end}}<p id="status"></p>
+<script>
+function openDoor(id, b) {
+	b.disabled = true;
+	document.getElementById("status").textContent = "Opening...";
+	fetch(location.pathname.replace(/\/$/, "") + "/open/" + id, {method: "POST"}).then(function (r) {
+		return r.text().then(function (t) {
+			document.getElementById("status").textContent = r.ok ? "The door is open." : t;
+		});
+	}).catch(function (e) {
+		document.getElementById("status").textContent = e;
+	}).then(function () {
+		b.disabled = false;
+	});
+}
+</script>
+</body>
+</html>
+`))
+
+// guestPageDoor is a door shown on the guest page
+type guestPageDoor struct {
+	ID   int
+	Name string
+}
+
+// GuestRequest holds the details of a guest pass to create
+type GuestRequest struct {
+	Name      string    `json:"name"`      // Name of the guest
+	Doors     []int     `json:"doors"`     // Door numbers the guest may open
+	ValidFrom time.Time `json:"validFrom"` // Start of the period the pass is valid for (defaults to now)
+	ValidTo   time.Time `json:"validTo"`   // End of the period the pass is valid for
+	MaxUses   int       `json:"maxUses"`   // Maximum number of uses (0 for no limit)
+}
+
+// GuestResponse holds a created guest pass and the code to give to the guest
+type GuestResponse struct {
+	Pass GuestPass `json:"pass"` // Guest pass
+	Code string    `json:"code"` // Code the guest uses, only returned when the pass is created
+	Path string    `json:"path"` // Path of the guest page
+}
+
+// GuestController handles the Web Methods for managing and using guest passes.
+type GuestController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *GuestController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/guests").Name("GetGuests").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleGetGuests))))
+	router.Methods("POST").Path("/guests").Name("CreateGuest").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleCreateGuest))))
+	router.Methods("DELETE").Path("/guests/{id}").Name("DeleteGuest").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleDeleteGuest))))
+
+	// The guest code is the credential, so these are not authorized. They are rate limited to slow guessing.
+	router.Methods("GET").Path("/guest/{code}").Name("GuestPage").
+		Handler(RateLimit(c, s, Logger(c, http.HandlerFunc(c.handleGuestPage))))
+	router.Methods("POST").Path("/guest/{code}/open/{doorNo}").Name("GuestOpenDoor").
+		Handler(RateLimit(c, s, Logger(c, http.HandlerFunc(c.handleGuestOpen))))
+}
+
+// handleGetGuests returns the guest passes
+func (c *GuestController) handleGetGuests(w http.ResponseWriter, r *http.Request) {
+	if !c.available(w) {
+		return
+	}
+	lst, err := c.Srv.GuestPasses.List()
+	if err != nil {
+		c.LogError("Error listing guest passes. ", err.Error())
+		http.Error(w, "Error listing guest passes", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, lst)
+}
+
+// handleCreateGuest creates a guest pass from the JSON body and returns the code
+func (c *GuestController) handleCreateGuest(w http.ResponseWriter, r *http.Request) {
+	if !c.available(w) {
+		return
+	}
+	req := GuestRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid guest pass. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ValidFrom.IsZero() {
+		req.ValidFrom = time.Now()
+	}
+	if req.Name == "" || len(req.Doors) == 0 || !req.ValidTo.After(req.ValidFrom) || req.MaxUses < 0 {
+		http.Error(w, "A name, doors and a valid period are required", http.StatusBadRequest)
+		return
+	}
+	for _, d := range req.Doors {
+		if dc := c.Srv.Config.Door(d); dc == nil || !dc.Enabled {
+			http.Error(w, fmt.Sprintf("Door %d does not exist or is disabled", d), http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, code, err := c.Srv.GuestPasses.Create(GuestPass{
+		Name:      req.Name,
+		Doors:     req.Doors,
+		ValidFrom: req.ValidFrom.UTC(),
+		ValidTo:   req.ValidTo.UTC(),
+		MaxUses:   req.MaxUses,
+		CreatedBy: RequestPrincipal(r).Name,
+	})
+	if err != nil {
+		c.LogError("Error creating guest pass. ", err.Error())
+		http.Error(w, "Error creating guest pass", http.StatusInternalServerError)
+		return
+	}
+	c.LogInfo("Guest pass ", p.ID, " created for ", p.Name, " by ", p.CreatedBy)
+	b, err := json.Marshal(GuestResponse{Pass: p, Code: code, Path: "/guest/" + code})
+	if err != nil {
+		http.Error(w, "Error serializing response. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// handleDeleteGuest revokes the guest pass
+func (c *GuestController) handleDeleteGuest(w http.ResponseWriter, r *http.Request) {
+	if !c.available(w) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	err := c.Srv.GuestPasses.Delete(id)
+	switch {
+	case errors.Is(err, ErrGuestPassNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		c.LogError("Error deleting guest pass ", id, ". ", err.Error())
+		http.Error(w, "Error deleting guest pass", http.StatusInternalServerError)
+		return
+	}
+	c.LogInfo("Guest pass ", id, " revoked by ", RequestPrincipal(r).Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGuestPage shows the doors the guest pass can open
+func (c *GuestController) handleGuestPage(w http.ResponseWriter, r *http.Request) {
+	if !c.available(w) {
+		return
+	}
+	p, err := c.Srv.GuestPasses.Lookup(strings.ToUpper(mux.Vars(r)["code"]))
+	if err != nil || !p.IsValid(time.Now()) {
+		c.LogInfo("Invalid guest code used from ", r.RemoteAddr)
+		http.Error(w, ErrGuestPassInvalid.Error(), http.StatusForbidden)
+		return
+	}
+
+	v := struct {
+		Name    string
+		ValidTo string
+		Doors   []guestPageDoor
+	}{Name: p.Name, ValidTo: p.ValidTo.In(c.Srv.Config.Location()).Format("Mon 2 Jan 15:04")}
+	for _, d := range p.Doors {
+		if dc := c.Srv.Config.Door(d); dc != nil && dc.Enabled {
+			v.Doors = append(v.Doors, guestPageDoor{ID: d, Name: dc.Name})
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	guestPage.Execute(w, v)
+}
+
+// handleGuestOpen opens the door if the guest pass allows it, counting the use
+func (c *GuestController) handleGuestOpen(w http.ResponseWriter, r *http.Request) {
+	if !c.available(w) {
+		return
+	}
+	code := strings.ToUpper(mux.Vars(r)["code"])
+	doorNo, _ := strconv.Atoi(mux.Vars(r)["doorNo"])
+
+	p, err := c.Srv.GuestPasses.Use(code, doorNo)
+	if err != nil {
+		if !errors.Is(err, ErrGuestPassInvalid) {
+			c.LogError("Error using guest pass. ", err.Error())
+			http.Error(w, "Error using guest pass", http.StatusInternalServerError)
+			return
+		}
+		c.LogInfo("Guest pass refused for door ", doorNo, " from ", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	o := CommandOrigin{Source: SourceGuest, RemoteAddr: r.RemoteAddr, Principal: "guest:" + p.Name}
+	err = c.Srv.RoomService.OpenDoor(doorNo, o)
+	if errors.Is(err, ErrDoorAlreadyOpen) || errors.Is(err, ErrDoorMoving) || errors.Is(err, ErrDoorBusy) || errors.Is(err, ErrDoorCooldown) {
+		// The door was not actuated, so the use does not count
+		if rerr := c.Srv.GuestPasses.Refund(code); rerr != nil {
+			c.LogError("Error refunding guest pass ", p.ID, ". ", rerr.Error())
+		}
+		p.Uses--
+	}
+
+	name := fmt.Sprintf("Door %d", doorNo)
+	if dc := c.Srv.Config.Door(doorNo); dc != nil {
+		name = dc.Name
+	}
+	uses := fmt.Sprintf("use %d", p.Uses)
+	if p.MaxUses > 0 {
+		uses = fmt.Sprintf("use %d of %d", p.Uses, p.MaxUses)
+	}
+	msg := fmt.Sprintf("Guest %s opened %s's door (%s).", p.Name, name, uses)
+	if err != nil {
+		msg = fmt.Sprintf("Guest %s tried to open %s's door. %s.", p.Name, name, err.Error())
+	}
+	if nerr := c.Srv.NotifyService.Notify(NotifyGuestAccess, msg); nerr != nil {
+		c.LogError("Error notifying guest access. ", nerr.Error())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), commandStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// available writes an error and returns false if guest passes are not available
+func (c *GuestController) available(w http.ResponseWriter) bool {
+	if c.Srv.GuestPasses == nil {
+		http.Error(w, "Guest passes are not available", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *GuestController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("GuestController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *GuestController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("GuestController: [Err] ", a)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// guestPassesBucket is the bolt bucket the guest passes are stored in, keyed by the code hash
+var guestPassesBucket = []byte("guests")
+
+// guestCodeChars are the characters guest codes are made of, leaving out the easily confused ones
+const guestCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// guestCodeLength is the number of characters in a guest code
+const guestCodeLength = 8
+
+// Guest pass errors
+var (
+	ErrGuestPassInvalid  = errors.New("invalid or expired guest pass")
+	ErrGuestPassNotFound = errors.New("guest pass does not exist")
+)
+
+// GuestPass allows a guest to open specific doors within a period of time. Only the hash of the code is stored.
+type GuestPass struct {
+	ID        string    `json:"id"`        // Pass ID, used to manage the pass
+	Name      string    `json:"name"`      // Name of the guest, e.g. courier
+	Doors     []int     `json:"doors"`     // Door numbers the guest may open
+	ValidFrom time.Time `json:"validFrom"` // Start of the period the pass is valid for
+	ValidTo   time.Time `json:"validTo"`   // End of the period the pass is valid for
+	MaxUses   int       `json:"maxUses"`   // Maximum number of times the pass can be used (0 for no limit)
+	Uses      int       `json:"uses"`      // Number of times the pass has been used
+	Created   time.Time `json:"created"`   // Time the pass was created
+	CreatedBy string    `json:"createdBy"` // Principal that created the pass
+}
+
+// IsValid returns whether the pass is within its valid period and has uses left at the specified time
+func (g *GuestPass) IsValid(at time.Time) bool {
+	if at.Before(g.ValidFrom) || at.After(g.ValidTo) {
+		return false
+	}
+	return g.MaxUses <= 0 || g.Uses < g.MaxUses
+}
+
+// Allows returns whether the pass can be used to open the door at the specified time
+func (g *GuestPass) Allows(doorNo int, at time.Time) bool {
+	if !g.IsValid(at) {
+		return false
+	}
+	for _, d := range g.Doors {
+		if d == doorNo {
+			return true
+		}
+	}
+	return false
+}
+
+// GuestPasses stores the guest passes
+type GuestPasses struct {
+	Srv *Server  // Server instance
+	db  *bolt.DB // Database, shared with the door event history
+}
+
+// Open creates the guest pass bucket in the database
+func (g *GuestPasses) Open(db *bolt.DB) error {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(guestPassesBucket)
+		return err
+	}); err != nil {
+		return err
+	}
+	g.db = db
+	return nil
+}
+
+// Create stores a new guest pass and returns the code the guest must use
+func (g *GuestPasses) Create(p GuestPass) (GuestPass, string, error) {
+	code, err := newGuestCode()
+	if err != nil {
+		return p, "", err
+	}
+	p.ID = newID()
+	p.Uses = 0
+	p.Created = time.Now().UTC()
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return p, "", err
+	}
+	err = g.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(guestPassesBucket).Put([]byte(HashToken(code)), b)
+	})
+	return p, code, err
+}
+
+// List returns the guest passes, most recently created first
+func (g *GuestPasses) List() ([]GuestPass, error) {
+	lst := []GuestPass{}
+	err := g.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(guestPassesBucket).ForEach(func(k, v []byte) error {
+			p := GuestPass{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			lst = append(lst, p)
+			return nil
+		})
+	})
+	sort.Slice(lst, func(i, j int) bool { return lst[i].Created.After(lst[j].Created) })
+	return lst, err
+}
+
+// Delete revokes the guest pass
+func (g *GuestPasses) Delete(id string) error {
+	return g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(guestPassesBucket)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			p := GuestPass{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if p.ID == id {
+				return c.Delete()
+			}
+		}
+		return ErrGuestPassNotFound
+	})
+}
+
+// Lookup returns the pass for the code
+func (g *GuestPasses) Lookup(code string) (GuestPass, error) {
+	p := GuestPass{}
+	err := g.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(guestPassesBucket).Get([]byte(HashToken(code)))
+		if v == nil {
+			return ErrGuestPassInvalid
+		}
+		return json.Unmarshal(v, &p)
+	})
+	return p, err
+}
+
+// Use counts a use of the pass to open the door. Returns ErrGuestPassInvalid if the pass
+// does not allow the door to be opened now.
+func (g *GuestPasses) Use(code string, doorNo int) (GuestPass, error) {
+	return g.update(code, func(p *GuestPass) error {
+		if !p.Allows(doorNo, time.Now()) {
+			return ErrGuestPassInvalid
+		}
+		p.Uses++
+		return nil
+	})
+}
+
+// Refund reverses a use of the pass, used when the door was not actuated
+func (g *GuestPasses) Refund(code string) error {
+	_, err := g.update(code, func(p *GuestPass) error {
+		if p.Uses > 0 {
+			p.Uses--
+		}
+		return nil
+	})
+	return err
+}
+
+// update applies the change to the pass for the code in a single transaction
+func (g *GuestPasses) update(code string, fn func(p *GuestPass) error) (GuestPass, error) {
+	p := GuestPass{}
+	k := []byte(HashToken(code))
+	err := g.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(guestPassesBucket)
+		v := b.Get(k)
+		if v == nil {
+			return ErrGuestPassInvalid
+		}
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		if err := fn(&p); err != nil {
+			return err
+		}
+		nv, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return b.Put(k, nv)
+	})
+	return p, err
+}
+
+// Run is called from the scheduler (ClockWerk). This function removes the passes that expired over a day ago.
+func (g *GuestPasses) Run() {
+	before := time.Now().Add(-24 * time.Hour)
+	n := 0
+	err := g.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(guestPassesBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			p := GuestPass{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if p.ValidTo.Before(before) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		g.logError("Error removing expired guest passes. ", err.Error())
+	} else if n > 0 {
+		g.logInfo("Removed ", n, " expired guest passes")
+	}
+}
+
+// newGuestCode returns a new random guest code
+func newGuestCode() (string, error) {
+	b := make([]byte, guestCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = guestCodeChars[int(b[i])%len(guestCodeChars)]
+	}
+	return string(b), nil
+}
+
+// logInfo logs an information message to the logger
+func (g *GuestPasses) logInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("GuestPasses: [Inf] ", a)
+}
+
+// logError logs an error message to the logger
+func (g *GuestPasses) logError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("GuestPasses: [Err] ", a)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestGuestPasses opens the guest passes in a temporary database
+func openTestGuestPasses(t *testing.T) (*GuestPasses, func()) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "history.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	g := &GuestPasses{}
+	if err := g.Open(db); err != nil {
+		t.Fatal(err)
+	}
+	return g, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGuestPassAllows(t *testing.T) {
+	from := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	pass := GuestPass{Doors: []int{1, 3}, ValidFrom: from, ValidTo: to, MaxUses: 2}
+	used := pass
+	used.Uses = 2
+	unlimited := used
+	unlimited.MaxUses = 0
+
+	tests := []struct {
+		name   string
+		pass   GuestPass
+		doorNo int
+		at     time.Time
+		want   bool
+	}{
+		{"valid", pass, 3, from.Add(time.Hour), true},
+		{"at start", pass, 1, from, true},
+		{"before start", pass, 1, from.Add(-time.Minute), false},
+		{"at end", pass, 1, to, true},
+		{"expired", pass, 1, to.Add(time.Minute), false},
+		{"other door", pass, 2, from.Add(time.Hour), false},
+		{"uses exhausted", used, 1, from.Add(time.Hour), false},
+		{"unlimited uses", unlimited, 1, from.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pass.Allows(tt.doorNo, tt.at); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuestPassIsValid(t *testing.T) {
+	from := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	tests := []struct {
+		name string
+		pass GuestPass
+		at   time.Time
+		want bool
+	}{
+		{"valid", GuestPass{ValidFrom: from, ValidTo: to}, from.Add(time.Hour), true},
+		{"not started", GuestPass{ValidFrom: from, ValidTo: to}, from.Add(-time.Second), false},
+		{"expired", GuestPass{ValidFrom: from, ValidTo: to}, to.Add(time.Second), false},
+		{"uses left", GuestPass{ValidFrom: from, ValidTo: to, MaxUses: 2, Uses: 1}, from, true},
+		{"uses exhausted", GuestPass{ValidFrom: from, ValidTo: to, MaxUses: 2, Uses: 2}, from, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pass.IsValid(tt.at); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuestPassesCreate(t *testing.T) {
+	g, done := openTestGuestPasses(t)
+	defer done()
+
+	p, code, err := g.Create(GuestPass{Name: "Courier", Doors: []int{1}, ValidTo: time.Now().Add(time.Hour), Uses: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID == "" || p.Uses != 0 || p.Created.IsZero() {
+		t.Errorf("got %+v, want a new pass with an id and no uses", p)
+	}
+	if len(code) != guestCodeLength || strings.Trim(code, guestCodeChars) != "" {
+		t.Errorf("got code %q, want %d characters from %s", code, guestCodeLength, guestCodeChars)
+	}
+
+	// Only the hash of the code is stored
+	g.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(guestPassesBucket)
+		if b.Get([]byte(code)) != nil || b.Get([]byte(HashToken(code))) == nil {
+			t.Error("pass not stored under the hash of the code")
+		}
+		return nil
+	})
+
+	if got, err := g.Lookup(code); err != nil || got.ID != p.ID {
+		t.Errorf("got %+v, %v looking up the code, want pass %s", got, err, p.ID)
+	}
+	if _, err := g.Lookup(HashToken(code)); !errors.Is(err, ErrGuestPassInvalid) {
+		t.Errorf("got %v looking up the hash, want %v", err, ErrGuestPassInvalid)
+	}
+
+	other, _, err := g.Create(GuestPass{Name: "Cleaner", Doors: []int{1}, ValidTo: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == p.ID {
+		t.Error("passes created with the same id")
+	}
+	if lst, err := g.List(); err != nil || len(lst) != 2 {
+		t.Errorf("got %d passes, %v, want 2", len(lst), err)
+	}
+}
+
+func TestGuestPassesUse(t *testing.T) {
+	g, done := openTestGuestPasses(t)
+	defer done()
+	now := time.Now()
+	_, code, err := g.Create(GuestPass{Name: "Courier", Doors: []int{1}, ValidFrom: now.Add(-time.Hour), ValidTo: now.Add(time.Hour), MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, later, err := g.Create(GuestPass{Name: "Cleaner", Doors: []int{1}, ValidFrom: now.Add(time.Hour), ValidTo: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step uses or refunds a pass, and checks the uses counted afterwards
+	steps := []struct {
+		name     string
+		code     string
+		doorNo   int
+		refund   bool
+		wantErr  error
+		wantUses int
+	}{
+		{"first use", code, 1, false, nil, 1},
+		{"door not on pass", code, 2, false, ErrGuestPassInvalid, 1},
+		{"second use", code, 1, false, nil, 2},
+		{"uses exhausted", code, 1, false, ErrGuestPassInvalid, 2},
+		{"refund", code, 0, true, nil, 1},
+		{"use after refund", code, 1, false, nil, 2},
+		{"not started", later, 1, false, ErrGuestPassInvalid, 0},
+		{"refund without uses", later, 0, true, nil, 0},
+		{"unknown code", "AAAAAAAA", 1, false, ErrGuestPassInvalid, -1},
+	}
+	for _, s := range steps {
+		var err error
+		if s.refund {
+			err = g.Refund(s.code)
+		} else {
+			_, err = g.Use(s.code, s.doorNo)
+		}
+		if !errors.Is(err, s.wantErr) {
+			t.Errorf("%s: got error %v, want %v", s.name, err, s.wantErr)
+		}
+		if s.wantUses < 0 {
+			continue
+		}
+		if p, err := g.Lookup(s.code); err != nil || p.Uses != s.wantUses {
+			t.Errorf("%s: got %d uses, %v, want %d", s.name, p.Uses, err, s.wantUses)
+		}
+	}
+}
+
+func TestGuestPassesExpiry(t *testing.T) {
+	g, done := openTestGuestPasses(t)
+	defer done()
+	now := time.Now()
+	expired, _, _ := g.Create(GuestPass{Name: "Yesterday", Doors: []int{1}, ValidFrom: now.Add(-50 * time.Hour), ValidTo: now.Add(-25 * time.Hour)})
+	recent, _, _ := g.Create(GuestPass{Name: "Today", Doors: []int{1}, ValidFrom: now.Add(-2 * time.Hour), ValidTo: now.Add(-time.Hour)})
+	current, _, _ := g.Create(GuestPass{Name: "Now", Doors: []int{1}, ValidFrom: now.Add(-time.Hour), ValidTo: now.Add(time.Hour)})
+
+	// Passes are kept for a day after they expire
+	g.Run()
+	lst, err := g.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, p := range lst {
+		ids[p.ID] = true
+	}
+	if ids[expired.ID] || !ids[recent.ID] || !ids[current.ID] || len(lst) != 2 {
+		t.Errorf("got passes %v, want %s and %s", ids, recent.ID, current.ID)
+	}
+
+	if err := g.Delete(current.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Delete(current.ID); !errors.Is(err, ErrGuestPassNotFound) {
+		t.Errorf("got %v deleting a deleted pass, want %v", err, ErrGuestPassNotFound)
+	}
+}
+
+// newTestGuestRouter returns a router for the guest controller of a server with one
+// closed door, and the guest passes
+func newTestGuestRouter(t *testing.T) (*mux.Router, *GuestController, func()) {
+	r, _, _ := newTestRoomService(true, false)
+	g, done := openTestGuestPasses(t)
+	r.Srv.GuestPasses = g
+	router := mux.NewRouter()
+	c := &GuestController{}
+	c.AddController(router, r.Srv)
+	return router, c, done
+}
+
+func TestGuestCreateHandler(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		req        GuestRequest
+		wantStatus int
+	}{
+		{"valid", GuestRequest{Name: "Courier", Doors: []int{1}, ValidTo: now.Add(time.Hour), MaxUses: 1}, http.StatusCreated},
+		{"no name", GuestRequest{Doors: []int{1}, ValidTo: now.Add(time.Hour)}, http.StatusBadRequest},
+		{"no doors", GuestRequest{Name: "Courier", ValidTo: now.Add(time.Hour)}, http.StatusBadRequest},
+		{"unknown door", GuestRequest{Name: "Courier", Doors: []int{2}, ValidTo: now.Add(time.Hour)}, http.StatusBadRequest},
+		{"ends before start", GuestRequest{Name: "Courier", Doors: []int{1}, ValidFrom: now.Add(2 * time.Hour), ValidTo: now.Add(time.Hour)}, http.StatusBadRequest},
+		{"expired", GuestRequest{Name: "Courier", Doors: []int{1}, ValidTo: now.Add(-time.Hour)}, http.StatusBadRequest},
+		{"negative uses", GuestRequest{Name: "Courier", Doors: []int{1}, ValidTo: now.Add(time.Hour), MaxUses: -1}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c, done := newTestGuestRouter(t)
+			defer done()
+
+			b, _ := json.Marshal(tt.req)
+			w := httptest.NewRecorder()
+			c.handleCreateGuest(w, httptest.NewRequest("POST", "/guests", bytes.NewReader(b)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d. %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got content type %q, want application/json", ct)
+			}
+			resp := GuestResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Path != "/guest/"+resp.Code || resp.Pass.Name != "Courier" {
+				t.Errorf("got %+v, want the courier pass and its path", resp)
+			}
+			if p, err := c.Srv.GuestPasses.Lookup(resp.Code); err != nil || p.ID != resp.Pass.ID {
+				t.Errorf("created pass not found. %v", err)
+			}
+		})
+	}
+}
+
+func TestGuestOpenHandler(t *testing.T) {
+	router, c, done := newTestGuestRouter(t)
+	defer done()
+	now := time.Now()
+	_, code, err := c.Srv.GuestPasses.Create(GuestPass{Name: "Courier", Doors: []int{1}, ValidFrom: now.Add(-time.Hour), ValidTo: now.Add(time.Hour), MaxUses: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step opens the door with the pass, and checks the uses counted afterwards
+	steps := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantUses   int
+	}{
+		{"open", "/guest/" + code + "/open/1", http.StatusNoContent, 1},
+		{"already open is refunded", "/guest/" + strings.ToLower(code) + "/open/1", http.StatusConflict, 1},
+		{"door not on pass", "/guest/" + code + "/open/2", http.StatusForbidden, 1},
+		{"unknown code", "/guest/AAAAAAAA/open/1", http.StatusForbidden, 1},
+	}
+	for _, s := range steps {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", s.path, nil))
+		if w.Code != s.wantStatus {
+			t.Errorf("%s: got status %d, want %d. %s", s.name, w.Code, s.wantStatus, w.Body.String())
+		}
+		if p, _ := c.Srv.GuestPasses.Lookup(code); p.Uses != s.wantUses {
+			t.Errorf("%s: got %d uses, want %d", s.name, p.Uses, s.wantUses)
+		}
+	}
+}
+
+func TestGuestPageHandler(t *testing.T) {
+	router, c, done := newTestGuestRouter(t)
+	defer done()
+	now := time.Now()
+	pass := func(from time.Time, to time.Time) string {
+		_, code, err := c.Srv.GuestPasses.Create(GuestPass{Name: "Courier", Doors: []int{1}, ValidFrom: from, ValidTo: to})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name       string
+		code       string
+		wantStatus int
+	}{
+		{"valid", pass(now.Add(-time.Hour), now.Add(time.Hour)), http.StatusOK},
+		{"not started", pass(now.Add(time.Hour), now.Add(2*time.Hour)), http.StatusForbidden},
+		{"expired", pass(now.Add(-2*time.Hour), now.Add(-time.Hour)), http.StatusForbidden},
+		{"unknown code", "AAAAAAAA", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/guest/"+tt.code, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusOK && !strings.Contains(w.Body.String(), "Hello Courier") {
+				t.Error("guest page not shown")
+			}
+		})
+	}
+}
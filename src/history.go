@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Types of events recorded in the history
+const (
+	HistoryTransition = "transition" // A door changed state
+	HistoryCommand    = "command"    // A door command was performed
+	HistoryAlarm      = "alarm"      // An alarm was raised
+)
+
+// historyEventsBucket is the name of the bolt bucket the door events are stored in
+var historyEventsBucket = []byte("events")
+
+// HistoryEvent is a door event recorded in the history
+type HistoryEvent struct {
+	Time       time.Time  `json:"time"`                 // Time of the event
+	Type       string     `json:"type"`                 // Event type: transition, command or alarm
+	DoorNo     int        `json:"door"`                 // Door number
+	State      DoorStatus `json:"state,omitempty"`      // New door state (transitions)
+	Action     string     `json:"action,omitempty"`     // Action requested (commands)
+	Source     string     `json:"source,omitempty"`     // Channel the command arrived on (commands)
+	RemoteAddr string     `json:"remoteAddr,omitempty"` // Address of the caller (commands)
+	Result     string     `json:"result,omitempty"`     // ok, or the reason the command failed (commands)
+	Message    string     `json:"message,omitempty"`    // Alarm message (alarms)
+	Sensor     string     `json:"sensor,omitempty"`     // Temperature sensor name (sensor alarms)
+}
+
+// HistoryQuery holds the criteria used to query the history
+type HistoryQuery struct {
+	DoorNo int       // Only return events for this door, 0 for all doors
+	From   time.Time // Earliest event time
+	To     time.Time // Latest event time
+	Limit  int       // Maximum number of events to return
+}
+
+// History records the door events in an embedded database so that they survive restarts
+type History struct {
+	Srv       *Server            // Server instance
+	Path      string             // Path to the database file
+	db        *bolt.DB           // Database
+	lastState map[int]DoorStatus // Last recorded state by door number
+	mu        sync.Mutex         // Protects the last recorded states
+}
+
+// Open opens, creating if required, the history database
+func (h *History) Open() error {
+	if h.Path == "" {
+		h.Path = filepath.Join("data", "history.db")
+	}
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0755); err != nil {
+		return err
+	}
+	db, err := bolt.Open(h.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyEventsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return err
+	}
+	h.db = db
+	h.lastState = make(map[int]DoorStatus)
+	return nil
+}
+
+// Close closes the history database
+func (h *History) Close() {
+	if h.db != nil {
+		h.db.Close()
+	}
+}
+
+// Start subscribes to the room state changes and records them
+func (h *History) Start() {
+	sub := h.Srv.State.Subscribe()
+	go func() {
+		for e := range sub.C {
+			he := HistoryEvent{Time: e.Time}
+			switch e.Type {
+			case EventDoor:
+				if !h.isTransition(*e.Door) {
+					continue
+				}
+				he.Type = HistoryTransition
+				he.DoorNo = e.Door.ID
+				he.State = e.Door.State
+			case EventCommand:
+				he.Type = HistoryCommand
+				he.DoorNo = e.Command.DoorNo
+				he.Action = e.Command.Action
+				he.Source = e.Command.Origin.Source
+				he.RemoteAddr = e.Command.Origin.RemoteAddr
+				he.Result = "ok"
+				if !e.Command.Success {
+					he.Result = e.Command.Error
+				}
+			case EventAlarm:
+				he.Type = HistoryAlarm
+				he.DoorNo = e.Alarm.DoorNo
+				he.Message = e.Alarm.Message
+				he.Sensor = e.Alarm.Sensor
+			default:
+				continue
+			}
+			if err := h.Record(he); err != nil {
+				h.logError("Error recording ", he.Type, " event. ", err.Error())
+			}
+		}
+	}()
+}
+
+// Run is called from the scheduler (ClockWerk). This function removes the events
+// that are older than the retention period.
+func (h *History) Run() {
+	days := h.Srv.Config.HistoryRetention
+	if days <= 0 {
+		return
+	}
+	n, err := h.Prune(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		h.logError("Error removing old events. ", err.Error())
+		return
+	}
+	if n != 0 {
+		h.logInfo("Removed ", n, " events older than ", days, " days")
+	}
+}
+
+// Record stores the event
+func (h *History) Record(e HistoryEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(historyEventsBucket)
+		seq, err := bk.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bk.Put(historyKey(e.Time, seq), b)
+	})
+}
+
+// Query returns the events matching the query, most recent first
+func (h *History) Query(q HistoryQuery) ([]HistoryEvent, error) {
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	lst := []HistoryEvent{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyEventsBucket).Cursor()
+		from := historyKey(q.From, 0)
+		k, v := c.Seek(historyKey(q.To.Add(time.Nanosecond), 0))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && string(k) >= string(from); k, v = c.Prev() {
+			e := HistoryEvent{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.DoorNo != 0 && e.DoorNo != q.DoorNo {
+				continue
+			}
+			lst = append(lst, e)
+			if q.Limit > 0 && len(lst) >= q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return lst, err
+}
+
+// Prune removes the events recorded before the specified time. Returns the number of events removed.
+func (h *History) Prune(before time.Time) (int, error) {
+	n := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyEventsBucket).Cursor()
+		end := historyKey(before, 0)
+		for k, _ := c.First(); k != nil && string(k) < string(end); k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// isTransition returns whether the door event is a change of the door travel state
+func (h *History) isTransition(d DoorState) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastState[d.ID] == d.State {
+		return false
+	}
+	h.lastState[d.ID] = d.State
+	return true
+}
+
+// historyKey builds the database key for an event. Keys sort in time order.
+func historyKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	ns := int64(0)
+	if !t.IsZero() {
+		ns = t.UnixNano()
+	}
+	binary.BigEndian.PutUint64(k[:8], uint64(ns))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+// logInfo logs an information message to the logger
+func (h *History) logInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("History: [Inf] ", a)
+}
+
+// logError logs an error message to the logger
+func (h *History) logError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("History: [Err] ", a)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestHistory opens a history in a temporary database holding an event for
+// each door every hour from midnight on the 1st of January 2026
+func openTestHistory(t *testing.T) (*History, func()) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &History{Path: filepath.Join(dir, "history.db")}
+	if err := h.Open(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	for hour := 0; hour < 6; hour++ {
+		for doorNo := 1; doorNo <= 2; doorNo++ {
+			if err := h.Record(HistoryEvent{Time: historyTime(hour), Type: HistoryTransition, DoorNo: doorNo}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return h, func() {
+		h.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// historyTime returns the time at the specified hour of the 1st of January 2026
+func historyTime(hour int) time.Time {
+	return time.Date(2026, 1, 1, hour, 0, 0, 0, time.UTC)
+}
+
+func TestHistoryQuery(t *testing.T) {
+	h, done := openTestHistory(t)
+	defer done()
+
+	tests := []struct {
+		name      string
+		query     HistoryQuery
+		wantCount int
+		wantFirst time.Time // Time of the first (most recent) event returned
+	}{
+		{"all", HistoryQuery{}, 12, historyTime(5)},
+		{"door", HistoryQuery{DoorNo: 2}, 6, historyTime(5)},
+		{"from", HistoryQuery{From: historyTime(4)}, 4, historyTime(5)},
+		{"to", HistoryQuery{To: historyTime(1)}, 4, historyTime(1)},
+		{"range", HistoryQuery{DoorNo: 1, From: historyTime(2), To: historyTime(3)}, 2, historyTime(3)},
+		{"limit", HistoryQuery{Limit: 3}, 3, historyTime(5)},
+		{"unknown door", HistoryQuery{DoorNo: 3}, 0, time.Time{}},
+		{"before any events", HistoryQuery{To: historyTime(0).Add(-time.Second)}, 0, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lst, err := h.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lst) != tt.wantCount {
+				t.Fatalf("got %d events, want %d", len(lst), tt.wantCount)
+			}
+			if len(lst) != 0 && !lst[0].Time.Equal(tt.wantFirst) {
+				t.Errorf("got first event at %v, want %v", lst[0].Time, tt.wantFirst)
+			}
+			for i := 1; i < len(lst); i++ {
+				if lst[i].Time.After(lst[i-1].Time) {
+					t.Fatalf("events not in most recent first order at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	tests := []struct {
+		name        string
+		before      time.Time
+		wantRemoved int
+	}{
+		{"nothing older", historyTime(0), 0},
+		{"some", historyTime(2).Add(time.Second), 6},
+		{"all", historyTime(6), 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, done := openTestHistory(t)
+			defer done()
+
+			n, err := h.Prune(tt.before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantRemoved {
+				t.Errorf("got %d events removed, want %d", n, tt.wantRemoved)
+			}
+			lst, err := h.Query(HistoryQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lst) != 12-tt.wantRemoved {
+				t.Errorf("got %d events left, want %d", len(lst), 12-tt.wantRemoved)
+			}
+			for _, e := range lst {
+				if e.Time.Before(tt.before) {
+					t.Errorf("event at %v not removed", e.Time)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryIsTransition(t *testing.T) {
+	h := &History{lastState: make(map[int]DoorStatus)}
+	tests := []struct {
+		door DoorState
+		want bool
+	}{
+		{DoorState{ID: 1, State: DoorOpening}, true},
+		{DoorState{ID: 1, State: DoorOpening}, false},
+		{DoorState{ID: 2, State: DoorOpening}, true},
+		{DoorState{ID: 1, State: DoorOpen}, true},
+	}
+	for i, tt := range tests {
+		if got := h.isTransition(tt.door); got != tt.want {
+			t.Errorf("%d: door %d %s: got %v, want %v", i, tt.door.ID, tt.door.State, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HistoryController handles the Web Methods for querying the recorded history.
+type HistoryController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *HistoryController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/history/events").Name("GetHistoryEvents").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetEvents))))
+	router.Methods("GET").Path("/history/temperature").Name("GetHistoryTemperature").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetTemperature))))
+}
+
+// handleGetEvents returns the door events matching the door, from, to and limit query parameters.
+// Times are in RFC3339 format.
+func (c *HistoryController) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	if c.Srv.History == nil {
+		http.Error(w, "History is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := HistoryQuery{Limit: 100}
+	v := r.URL.Query()
+	var err error
+	if d := v.Get("door"); d != "" {
+		if q.DoorNo, err = strconv.Atoi(d); err != nil {
+			http.Error(w, "Invalid door number", http.StatusBadRequest)
+			return
+		}
+	}
+	if q.From, err = parseTime(v.Get("from")); err != nil {
+		http.Error(w, "Invalid from time. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseTime(v.Get("to")); err != nil {
+		http.Error(w, "Invalid to time. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil || q.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if q.Limit > 1000 {
+		q.Limit = 1000
+	}
+
+	lst, err := c.Srv.History.Query(q)
+	if err != nil {
+		c.LogError("Error querying history. ", err.Error())
+		http.Error(w, "Error querying history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, lst)
+}
+
+// handleGetTemperature returns the temperature readings between the from and to query parameters
+// summarized into periods of the step query parameter (e.g. 15m, 1h).  The sensor query parameter
+// selects the sensor by name, defaulting to the sensor providing the room temperature.  The readings are returned
+// as CSV if format=csv is specified or text/csv is accepted, otherwise as JSON.
+func (c *HistoryController) handleGetTemperature(w http.ResponseWriter, r *http.Request) {
+	if c.Srv.TempHistory == nil {
+		http.Error(w, "Temperature history is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	v := r.URL.Query()
+	from, err := parseTime(v.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from time. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(v.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to time. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	step := time.Duration(0)
+	if s := v.Get("step"); s != "" {
+		if step, err = time.ParseDuration(s); err != nil || step < 0 {
+			http.Error(w, "Invalid step", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sensor := v.Get("sensor")
+	if sensor == "" {
+		sensor = c.Srv.RoomService.PrimarySensor()
+	}
+
+	pts, err := c.Srv.TempHistory.Query(sensor, from, to, step)
+	if err != nil {
+		c.LogError("Error querying temperature history. ", err.Error())
+		http.Error(w, "Error querying temperature history", http.StatusInternalServerError)
+		return
+	}
+
+	if v.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("content-type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "avg", "min", "max", "count"})
+		for _, p := range pts {
+			cw.Write([]string{
+				p.Time.Format(time.RFC3339),
+				strconv.FormatFloat(p.Avg, 'f', 2, 64),
+				strconv.FormatFloat(p.Min, 'f', 2, 64),
+				strconv.FormatFloat(p.Max, 'f', 2, 64),
+				strconv.Itoa(p.Count),
+			})
+		}
+		cw.Flush()
+		return
+	}
+	writeJSON(w, pts)
+}
+
+// parseTime parses an optional RFC3339 time from a query parameter
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// writeJSON serializes the value and writes it to the http response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error serializing response. "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.Write(b)
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *HistoryController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("HistoryController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *HistoryController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("HistoryController: [Err] ", a)
+}
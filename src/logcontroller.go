@@ -18,7 +18,7 @@ type LogController struct {
 func (c *LogController) AddController(router *mux.Router, s *Server) {
 	c.Srv = s
 	router.Methods("GET").Path("/log/get").Name("GetLogs").
-		Handler(Logger(c, http.HandlerFunc(c.handleGetLogs)))
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleGetLogs))))
 }
 
 func (c *LogController) handleGetLogs(w http.ResponseWriter, r *http.Request) {
@@ -2,14 +2,30 @@ package main
 
 import (
 	"net/http"
+	"strings"
 	"time"
 )
 
 // Logger will create a Logger Handler wrapper for the specified handler.
+// Only the path is logged, as the query may hold an access token, and guest codes are redacted.
 func Logger(c Controller, inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		inner.ServeHTTP(w, r)
-		c.LogInfo(r.Method, " ", r.RequestURI, " from ", r.RemoteAddr, " tool ", time.Since(start))
+		c.LogInfo(r.Method, " ", logPath(r), " from ", r.RemoteAddr, " tool ", time.Since(start))
 	})
 }
+
+// logPath returns the request path to log, with the guest pass code redacted
+// as it is the credential of the guest
+func logPath(r *http.Request) string {
+	p := r.URL.Path
+	if !strings.HasPrefix(p, "/guest/") {
+		return p
+	}
+	rest := strings.TrimPrefix(p, "/guest/")
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return "/guest/***" + rest[i:]
+	}
+	return "/guest/***"
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/kardianos/service"
@@ -14,8 +16,14 @@ var logger service.Logger
 func main() {
 	port := flag.Int("p", 20515, "Port Number to listen on")
 	svcFlag := flag.String("service", "", "Service action.  Valid actions are: 'start', 'stop', 'restart', 'instal' and 'uninstall'")
+	hashFlag := flag.String("hash", "", "Read an API token or password from stdin and print the hash to put in config.json.  Valid values are: 'token' and 'password'")
 	flag.Parse()
 
+	if *hashFlag != "" {
+		printHash(*hashFlag)
+		return
+	}
+
 	// Create a new server
 	s := &Server{
 		PortNo: *port,
@@ -67,3 +75,25 @@ func main() {
 		}
 	}
 }
+
+// printHash reads a token or password from stdin and prints its hash
+func printHash(kind string) {
+	fmt.Fprint(os.Stderr, "Enter the ", kind, ": ")
+	v, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && v == "" {
+		log.Fatal(err)
+	}
+	v = strings.TrimRight(v, "\r\n")
+	switch kind {
+	case "token":
+		fmt.Println(HashToken(v))
+	case "password":
+		h, err := HashPassword(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(h)
+	default:
+		fmt.Println(kind, "is an invalid hash type. Valid types are 'token' and 'password'")
+	}
+}
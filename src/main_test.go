@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+// testLogger writes the service log messages to the standard logger
+type testLogger struct{}
+
+func (testLogger) Error(v ...interface{}) error              { log.Print(v...); return nil }
+func (testLogger) Warning(v ...interface{}) error            { log.Print(v...); return nil }
+func (testLogger) Info(v ...interface{}) error               { log.Print(v...); return nil }
+func (testLogger) Errorf(f string, a ...interface{}) error   { log.Printf(f, a...); return nil }
+func (testLogger) Warningf(f string, a ...interface{}) error { log.Printf(f, a...); return nil }
+func (testLogger) Infof(f string, a ...interface{}) error    { log.Printf(f, a...); return nil }
+
+func TestMain(m *testing.M) {
+	logger = testLogger{}
+	os.Exit(m.Run())
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
@@ -54,11 +55,13 @@ func (m *Mqtt) Initialize() error {
 	})
 	opts.SetOnConnectHandler(func(client MQTT.Client) {
 		m.logInfo("Connected to the MQTT Broker. Subscribing to topics.")
-		if token := client.Subscribe("home/garage/door1/set", byte(1), nil); token.Wait() && token.Error() != nil {
-			panic(token.Error())
-		}
-		if token := client.Subscribe("home/garage/door2/set", byte(1), nil); token.Wait() && token.Error() != nil {
-			panic(token.Error())
+		for _, d := range m.Srv.Config.Doors {
+			if token := client.Subscribe(m.doorTopic(d.ID)+"/set", byte(1), nil); token.Wait() && token.Error() != nil {
+				panic(token.Error())
+			}
+			if token := client.Subscribe(m.doorTopic(d.ID)+"/autoclose", byte(1), nil); token.Wait() && token.Error() != nil {
+				panic(token.Error())
+			}
 		}
 		m.logInfo("Subscription complete.")
 	})
@@ -68,47 +71,56 @@ func (m *Mqtt) Initialize() error {
 			m.logInfo("Commands are currently being ignored")
 			return
 		}
-		if msg.Topic() == "home/garage/door1/set" {
-			if m.Srv.Config.EnableDoor1 {
-				pl := string(msg.Payload())
-				m.logInfo("Received Door 1 Set command with payload of: ", pl)
-				if pl == "ON" {
-					// Check if the door is open and close it
-					if !m.Srv.Room.Door1Closed {
-						m.logInfo("Closing door 1")
-						m.Srv.RoomService.OpenDoor(1)
-					}
-				} else if pl == "OFF" {
-					// Check if the door is closed an open it
-					if m.Srv.Room.Door1Closed {
-						m.logInfo("Opening door 1")
-						m.Srv.RoomService.OpenDoor(1)
-					}
-				}
-			} else {
-				m.logInfo("Door1 is disabled")
+		doorNo := 0
+		if _, err := fmt.Sscanf(msg.Topic(), "home/garage/door%d/", &doorNo); err != nil {
+			m.logError("Invalid command topic ", msg.Topic())
+			return
+		}
+		d, ok := m.Srv.State.Door(doorNo)
+		if !ok {
+			m.logError("Door", doorNo, " does not exist")
+			return
+		}
+		if !d.Enabled {
+			m.logInfo("Door", doorNo, " is disabled")
+			return
+		}
+		pl := string(msg.Payload())
+		if strings.HasSuffix(msg.Topic(), "/autoclose") {
+			m.logInfo("Received Door ", doorNo, " auto-close command with payload of: ", pl)
+			if pl != "CANCEL" {
+				m.logError("Invalid payload ", pl, " for door ", doorNo, " auto-close")
+				return
 			}
-		} else if msg.Topic() == "home/garage/door2/set" {
-			if m.Srv.Config.EnableDoor2 {
-				pl := string(msg.Payload())
-				m.logInfo("Received Door 2 Set command with payload of: ", pl)
-				if pl == "ON" {
-					// Check if the door is open and close it
-					if !m.Srv.Room.Door2Closed {
-						m.logInfo("Closing door 2")
-						m.Srv.RoomService.OpenDoor(2)
-					}
-				} else if pl == "OFF" {
-					// Check if the door is closed an open it
-					if m.Srv.Room.Door2Closed {
-						m.logInfo("Opening door 2")
-						m.Srv.RoomService.OpenDoor(2)
-					}
-				}
-			} else {
-				m.logInfo("Door2 is disabled")
+			if err := m.Srv.AutoCloser.Cancel(doorNo, CommandOrigin{Source: SourceMqtt}); err != nil {
+				m.logInfo("Door ", doorNo, " auto-close not cancelled. ", err.Error())
 			}
+			return
 		}
+		m.logInfo("Received Door ", doorNo, " Set command with payload of: ", pl)
+		if ok, _ := m.Srv.RateLimiter.Allow(SourceMqtt); !ok {
+			m.logError("Door ", doorNo, " command ignored. ", ErrRateLimited.Error())
+			return
+		}
+		// Run the command in the background as it waits for the door to finish moving
+		go func() {
+			var err error
+			if pl == "ON" {
+				m.logInfo("Closing door ", doorNo)
+				err = m.Srv.RoomService.CloseDoor(doorNo, CommandOrigin{Source: SourceMqtt})
+			} else if pl == "OFF" {
+				m.logInfo("Opening door ", doorNo)
+				err = m.Srv.RoomService.OpenDoor(doorNo, CommandOrigin{Source: SourceMqtt})
+			} else {
+				m.logError("Invalid payload ", pl, " for door ", doorNo)
+				return
+			}
+			if errors.Is(err, ErrDoorAlreadyOpen) || errors.Is(err, ErrDoorAlreadyClosed) || errors.Is(err, ErrDoorMoving) {
+				m.logInfo("Door ", doorNo, " command ignored. ", err.Error())
+			} else if err != nil {
+				m.logError("Door ", doorNo, " command failed. ", err.Error())
+			}
+		}()
 	})
 
 	m.client = MQTT.NewClient(opts)
@@ -142,52 +154,106 @@ func (m *Mqtt) SendTelemetry() error {
 		}
 	}
 
-	// DOOR 1
-	if m.Srv.Config.EnableDoor1 {
-		doorState := "OFF"
-		if m.Srv.Room.Door1Closed {
-			doorState = "ON"
-		}
-		m.logInfo("Publishing door1 state. ", doorState)
-		token := m.client.Publish("home/garage/door1", byte(0), true, doorState)
-		if token.Wait() && token.Error() != nil {
-			m.logError("Error sending door 1 state to MQTT Broker. ", token.Error())
-			return token.Error()
+	room := m.Srv.State.Snapshot()
+
+	// Doors
+	for _, d := range room.Doors {
+		if err := m.publishDoor(d); err != nil {
+			return err
 		}
-	} else {
-		m.logInfo("Publishing door1 state. Door1 is disabled.")
 	}
 
-	// DOOR 2
-	if m.Srv.Config.EnableDoor2 {
-		doorState := "OFF"
-		if m.Srv.Room.Door2Closed {
-			doorState = "ON"
+	// Temperature
+	if err := m.publishTemperature("", room.Temperature); err != nil {
+		return err
+	}
+	for _, sr := range room.Sensors {
+		if err := m.publishTemperature(sr.Name, sr.Temperature); err != nil {
+			return err
 		}
-		m.logInfo("Publishing door2 state. ", doorState)
-		token := m.client.Publish("home/garage/door2", byte(0), true, doorState)
-		if token.Wait() && token.Error() != nil {
-			m.logError("Error sending door 2 state to MQTT Broker. ", token.Error())
-			return token.Error()
+	}
+
+	m.LastUpdate = time.Now()
+	m.ignoreCommands = false
+
+	return nil
+}
+
+// Start subscribes to the room state changes and publishes them as they happen
+func (m *Mqtt) Start() {
+	sub := m.Srv.State.Subscribe()
+	go func() {
+		for e := range sub.C {
+			if !m.Srv.Config.EnableMqtt || m.client == nil || !m.client.IsConnected() {
+				continue
+			}
+			switch e.Type {
+			case EventDoor:
+				m.publishDoor(*e.Door)
+			case EventTemperature:
+				m.publishTemperature(e.Sensor, e.Temperature)
+			}
 		}
-	} else {
-		m.logInfo("Publishing door2 state. Door2 is disabled.")
+	}()
+}
+
+// publishDoor publishes the state of the door
+func (m *Mqtt) publishDoor(d DoorState) error {
+	if !d.Enabled {
+		m.logInfo("Publishing door", d.ID, " state. Door", d.ID, " is disabled.")
+		return nil
+	}
+	doorState := "OFF"
+	if d.Closed {
+		doorState = "ON"
 	}
+	m.logInfo("Publishing door", d.ID, " state. ", doorState)
+	token := m.client.Publish(m.doorTopic(d.ID), byte(0), true, doorState)
+	if token.Wait() && token.Error() != nil {
+		m.logError("Error sending door ", d.ID, " state to MQTT Broker. ", token.Error())
+		return token.Error()
+	}
+	m.logInfo("Publishing door", d.ID, " travel state. ", d.State)
+	token = m.client.Publish(m.doorTopic(d.ID)+"/state", byte(0), true, string(d.State))
+	if token.Wait() && token.Error() != nil {
+		m.logError("Error sending door ", d.ID, " travel state to MQTT Broker. ", token.Error())
+		return token.Error()
+	}
+	return nil
+}
 
-	// Temperature
-	m.logInfo("Publishing temperature. ", fmt.Sprintf("%.1f", m.Srv.Room.Temperature))
-	token := m.client.Publish("home/garage/temperature", byte(0), true, fmt.Sprintf("%.1f", m.Srv.Room.Temperature))
+// publishTemperature publishes the temperature of the named sensor, or the room temperature if no sensor is specified
+func (m *Mqtt) publishTemperature(sensor string, temp float64) error {
+	topic := "home/garage/temperature"
+	if sensor != "" {
+		topic += "/" + sensor
+	}
+	m.logInfo("Publishing temperature ", topic, ". ", fmt.Sprintf("%.1f", temp))
+	token := m.client.Publish(topic, byte(0), true, fmt.Sprintf("%.1f", temp))
 	if token.Wait() && token.Error() != nil {
 		m.logError("Error sending temperature state to MQTT Broker.", token.Error())
 		return token.Error()
 	}
+	return nil
+}
 
-	m.LastUpdate = time.Now()
-	m.ignoreCommands = false
-
+// Publish publishes the payload to the topic
+func (m *Mqtt) Publish(topic string, payload string, retained bool) error {
+	if !m.Srv.Config.EnableMqtt || m.client == nil || !m.client.IsConnected() {
+		return errors.New("not connected to the MQTT Broker")
+	}
+	token := m.client.Publish(topic, byte(0), retained, payload)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
 	return nil
 }
 
+// doorTopic returns the MQTT topic used to publish the state of the specified door
+func (m *Mqtt) doorTopic(doorNo int) string {
+	return fmt.Sprintf("home/garage/door%d", doorNo)
+}
+
 // logInfo logs an information message to the logger
 func (m *Mqtt) logInfo(v ...interface{}) {
 	a := fmt.Sprint(v...)
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	telegram "github.com/brumawen/telegram/src"
+)
+
+// Notification events, used to route the notifications to the notifiers
+const (
+	NotifyDoorOpen           = "doorOpen"           // A door has been open for longer than the alarm period
+	NotifyDoorEscalated      = "doorEscalated"      // A door has been open for longer than the escalation period
+	NotifyDoorClosed         = "doorClosed"         // A door that was notified as open has closed
+	NotifyDoorFault          = "doorFault"          // A door stopped part way, failed to move or its state is unknown
+	NotifyAutoCloseWarning   = "autoCloseWarning"   // A door is about to be closed automatically
+	NotifyAutoClosed         = "autoClosed"         // A door was closed automatically
+	NotifyAutoCloseCancelled = "autoCloseCancelled" // The auto-close of a door was cancelled
+	NotifyAutoCloseFailed    = "autoCloseFailed"    // A door could not be closed automatically
+	NotifyScheduled          = "schedule"           // A scheduled message, e.g. the temperatures
+	NotifySummary            = "summary"            // A daily or weekly summary report
+	NotifyGuestAccess        = "guestAccess"        // A guest pass was used to open a door
+	NotifySensorStale        = "sensorStale"        // A temperature sensor has stopped returning valid readings
+	NotifySensorRecovered    = "sensorRecovered"    // A stale temperature sensor is reading again
+	NotifyTempAlarm          = "tempAlarm"          // A temperature is beyond its threshold
+	NotifyTempNormal         = "tempNormal"         // A temperature is back within its threshold
+
+	notifyAllAlarms = "alarm" // Routes all alarm events to a notifier
+	notifyAll       = "*"     // Routes all events to a notifier
+)
+
+// notifyTimeout is the maximum time a notifier may take to send a message
+const notifyTimeout = 15 * time.Second
+
+// Notification holds a message to be sent by the notifiers
+type Notification struct {
+	Event   string    `json:"event"`   // Event that raised the notification
+	Alarm   bool      `json:"alarm"`   // Whether the notification is an alarm
+	Title   string    `json:"title"`   // Short title
+	Message string    `json:"message"` // Message text
+	Time    time.Time `json:"time"`    // Time the notification was raised
+}
+
+// Notifier defines an interface for a channel that notifications are sent over
+type Notifier interface {
+	Send(n Notification) error // Sends the notification
+}
+
+// NotifierConfig holds the configuration for a notification channel
+type NotifierConfig struct {
+	Name     string            `json:"name"`     // Name used in the logs, defaults to the type
+	Type     string            `json:"type"`     // Channel type: telegram, webhook, smtp, ntfy, gotify or mqtt
+	Enabled  bool              `json:"enabled"`  // Enable the channel
+	Events   []string          `json:"events"`   // Events sent over the channel: event names, "alarm" for all alarms, or empty for all events
+	URL      string            `json:"url"`      // Webhook URL, ntfy topic URL or Gotify server URL
+	Token    string            `json:"token"`    // Telegram bot token, ntfy access token or Gotify application token
+	ChatID   string            `json:"chatID"`   // Telegram chat ID
+	Headers  map[string]string `json:"headers"`  // Additional HTTP headers (webhook)
+	Topic    string            `json:"topic"`    // MQTT topic, defaults to home/garage/notify
+	Host     string            `json:"host"`     // SMTP server host
+	Port     int               `json:"port"`     // SMTP server port, defaults to 587
+	Username string            `json:"username"` // SMTP username
+	Password string            `json:"password"` // SMTP password
+	From     string            `json:"from"`     // SMTP sender address
+	To       []string          `json:"to"`       // SMTP recipient addresses
+	Priority int               `json:"priority"` // Alarm priority (ntfy 1-5, Gotify 0-10), 0 for the channel default
+}
+
+// Routes returns whether the notification event is sent over the channel
+func (c *NotifierConfig) Routes(n Notification) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == notifyAll || e == n.Event || (e == notifyAllAlarms && n.Alarm) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNotifier creates the notifier described by the configuration
+func NewNotifier(srv *Server, c NotifierConfig) (Notifier, error) {
+	switch c.Type {
+	case "telegram":
+		return &TelegramNotifier{Srv: srv, Token: c.Token, ChatID: c.ChatID}, nil
+	case "webhook":
+		if c.URL == "" {
+			return nil, errors.New("webhook URL has not been configured")
+		}
+		return &WebhookNotifier{URL: c.URL, Headers: c.Headers}, nil
+	case "smtp":
+		if c.Host == "" || len(c.To) == 0 {
+			return nil, errors.New("SMTP host and recipients have not been configured")
+		}
+		port := c.Port
+		if port == 0 {
+			port = 587
+		}
+		return &SmtpNotifier{
+			Addr:     net.JoinHostPort(c.Host, fmt.Sprint(port)),
+			Host:     c.Host,
+			Username: c.Username,
+			Password: c.Password,
+			From:     c.From,
+			To:       c.To,
+		}, nil
+	case "ntfy", "gotify":
+		if c.URL == "" {
+			return nil, errors.New("push URL has not been configured")
+		}
+		return &PushNotifier{Gotify: c.Type == "gotify", URL: c.URL, Token: c.Token, Priority: c.Priority}, nil
+	case "mqtt":
+		t := c.Topic
+		if t == "" {
+			t = "home/garage/notify"
+		}
+		return &MqttNotifier{Srv: srv, Topic: t}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type '%s'", c.Type)
+}
+
+// TelegramNotifier sends notifications to a Telegram chat. If the bot token and chat
+// are not configured, the settings of the telegram client package are used.
+type TelegramNotifier struct {
+	Srv    *Server // Server instance
+	Token  string  // Bot token
+	ChatID string  // Chat ID
+}
+
+// Send sends the notification message to the chat
+func (t *TelegramNotifier) Send(n Notification) error {
+	if t.Token == "" || t.ChatID == "" {
+		c := telegram.Client{
+			Logger:         logger,
+			VerboseLogging: t.Srv.VerboseLogging,
+		}
+		return c.SendMessage(n.Message)
+	}
+	u := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.Token)
+	return postForm(u, url.Values{"chat_id": {t.ChatID}, "text": {n.Message}})
+}
+
+// WebhookNotifier posts notifications as JSON to a URL
+type WebhookNotifier struct {
+	URL     string            // Webhook URL
+	Headers map[string]string // Additional HTTP headers
+}
+
+// Send posts the notification to the webhook
+func (w *WebhookNotifier) Send(n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	return doRequest(req)
+}
+
+// SmtpNotifier emails notifications
+type SmtpNotifier struct {
+	Addr     string   // Server address (host:port)
+	Host     string   // Server host, used to authenticate
+	Username string   // Username, empty to send without authenticating
+	Password string   // Password
+	From     string   // Sender address
+	To       []string // Recipient addresses
+}
+
+// Send emails the notification to the recipients
+func (s *SmtpNotifier) Send(n Notification) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + strings.Join(s.To, ", ") + "\r\n" +
+		"Subject: " + n.Title + "\r\n" +
+		"Date: " + n.Time.Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + n.Message + "\r\n"
+
+	// Same as smtp.SendMail, but with a timeout so a stalled server cannot hold up the notifications
+	conn, err := net.DialTimeout("tcp", s.Addr, notifyTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("SMTP server does not support authentication")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// PushNotifier sends notifications to an ntfy topic or a Gotify server
+type PushNotifier struct {
+	Gotify   bool   // Send to a Gotify server, otherwise to an ntfy topic
+	URL      string // ntfy topic URL, or Gotify server URL
+	Token    string // ntfy access token, or Gotify application token
+	Priority int    // Alarm priority, 0 for the default
+}
+
+// Send pushes the notification
+func (p *PushNotifier) Send(n Notification) error {
+	prio := 0
+	if n.Alarm {
+		prio = p.Priority
+	}
+
+	if p.Gotify {
+		m := map[string]interface{}{"title": n.Title, "message": n.Message}
+		if prio != 0 {
+			m["priority"] = prio
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", strings.TrimRight(p.URL, "/")+"/message", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", p.Token)
+		return doRequest(req)
+	}
+
+	req, err := http.NewRequest("POST", p.URL, strings.NewReader(n.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", n.Title)
+	if prio != 0 {
+		req.Header.Set("Priority", fmt.Sprint(prio))
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	return doRequest(req)
+}
+
+// MqttNotifier publishes notifications as JSON to an MQTT topic
+type MqttNotifier struct {
+	Srv   *Server // Server instance
+	Topic string  // Topic the notifications are published to
+}
+
+// Send publishes the notification
+func (m *MqttNotifier) Send(n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return m.Srv.MqttClient.Publish(m.Topic, string(b), false)
+}
+
+// postForm posts the form values to the URL
+func postForm(u string, v url.Values) error {
+	req, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(req)
+}
+
+// doRequest sends the request and checks that it succeeded. The URL is left out of the
+// errors, as it may hold a secret such as the Telegram bot token.
+func doRequest(req *http.Request) error {
+	c := http.Client{Timeout: notifyTimeout}
+	resp, err := c.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			return fmt.Errorf("%s %s: %v", ue.Op, req.URL.Host, ue.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
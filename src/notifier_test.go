@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestNotifierRoutes(t *testing.T) {
+	open := Notification{Event: NotifyDoorOpen, Alarm: true}
+	closed := Notification{Event: NotifyDoorClosed}
+	tests := []struct {
+		name       string
+		config     NotifierConfig
+		wantOpen   bool
+		wantClosed bool
+	}{
+		{"all events", NotifierConfig{Enabled: true}, true, true},
+		{"disabled", NotifierConfig{}, false, false},
+		{"wildcard", NotifierConfig{Enabled: true, Events: []string{"*"}}, true, true},
+		{"alarms", NotifierConfig{Enabled: true, Events: []string{"alarm"}}, true, false},
+		{"event", NotifierConfig{Enabled: true, Events: []string{NotifyDoorClosed}}, false, true},
+		{"other event", NotifierConfig{Enabled: true, Events: []string{NotifyTempAlarm}}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.Routes(open); got != tt.wantOpen {
+				t.Errorf("door open: got %v, want %v", got, tt.wantOpen)
+			}
+			if got := tt.config.Routes(closed); got != tt.wantClosed {
+				t.Errorf("door closed: got %v, want %v", got, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  NotifierConfig
+		want    Notifier
+		wantErr bool
+	}{
+		{"telegram", NotifierConfig{Type: "telegram", Token: "t", ChatID: "1"}, &TelegramNotifier{Token: "t", ChatID: "1"}, false},
+		{"webhook", NotifierConfig{Type: "webhook", URL: "http://hooks/1"}, &WebhookNotifier{URL: "http://hooks/1"}, false},
+		{"webhook without URL", NotifierConfig{Type: "webhook"}, nil, true},
+		{"smtp default port", NotifierConfig{Type: "smtp", Host: "mail", To: []string{"a@b"}}, &SmtpNotifier{Addr: "mail:587", Host: "mail", To: []string{"a@b"}}, false},
+		{"smtp without recipients", NotifierConfig{Type: "smtp", Host: "mail"}, nil, true},
+		{"gotify", NotifierConfig{Type: "gotify", URL: "http://push", Priority: 8}, &PushNotifier{Gotify: true, URL: "http://push", Priority: 8}, false},
+		{"ntfy without URL", NotifierConfig{Type: "ntfy"}, nil, true},
+		{"mqtt default topic", NotifierConfig{Type: "mqtt"}, &MqttNotifier{Topic: "home/garage/notify"}, false},
+		{"unknown", NotifierConfig{Type: "pager"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewNotifier(nil, tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			gb, _ := json.Marshal(got)
+			wb, _ := json.Marshal(tt.want)
+			if string(gb) != string(wb) {
+				t.Errorf("got %T %s, want %T %s", got, gb, tt.want, wb)
+			}
+		})
+	}
+}
+
+// webhookRecorder records the notifications posted to a test webhook
+type webhookRecorder struct {
+	*httptest.Server
+	mu   sync.Mutex
+	recv []Notification
+	hdr  http.Header
+}
+
+// newWebhookRecorder starts a webhook server that records the notifications posted to it
+func newWebhookRecorder() *webhookRecorder {
+	w := &webhookRecorder{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		n := Notification{}
+		json.Unmarshal(b, &n)
+		w.mu.Lock()
+		w.recv = append(w.recv, n)
+		w.hdr = r.Header
+		w.mu.Unlock()
+	}))
+	return w
+}
+
+// Received returns the notifications received by the webhook
+func (w *webhookRecorder) Received() []Notification {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Notification{}, w.recv...)
+}
+
+func TestNotifyRouting(t *testing.T) {
+	alarms := newWebhookRecorder()
+	defer alarms.Close()
+	closed := newWebhookRecorder()
+	defer closed.Close()
+
+	s := &Server{Config: &Config{Notifiers: []NotifierConfig{
+		{Type: "webhook", Enabled: true, URL: alarms.URL, Events: []string{"alarm"}, Headers: map[string]string{"X-Token": "secret"}},
+		{Type: "webhook", Enabled: true, URL: closed.URL, Events: []string{NotifyDoorClosed}},
+		{Type: "webhook", Enabled: false, URL: closed.URL},
+	}}}
+	n := &NotifyService{Srv: s}
+
+	tests := []struct {
+		event      string
+		wantAlarms int
+		wantClosed int
+	}{
+		{NotifyDoorOpen, 1, 0},
+		{NotifyDoorClosed, 1, 1},
+		{NotifyTempAlarm, 2, 1},
+		{NotifyTempNormal, 2, 1},
+	}
+	for _, tt := range tests {
+		if err := n.sendMessage(tt.event, "message"); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(alarms.Received()); got != tt.wantAlarms {
+			t.Errorf("%s: alarm webhook got %d notifications, want %d", tt.event, got, tt.wantAlarms)
+		}
+		if got := len(closed.Received()); got != tt.wantClosed {
+			t.Errorf("%s: closed webhook got %d notifications, want %d", tt.event, got, tt.wantClosed)
+		}
+	}
+
+	r := alarms.Received()[0]
+	if r.Event != NotifyDoorOpen || !r.Alarm || r.Title != "Garage alarm" || r.Message != "message" {
+		t.Errorf("got %+v, want a door open alarm", r)
+	}
+	if alarms.hdr.Get("X-Token") != "secret" {
+		t.Error("webhook header not sent")
+	}
+	if r := closed.Received()[0]; r.Alarm || r.Title != "Garage" {
+		t.Errorf("got %+v, want a door closed notification that is not an alarm", r)
+	}
+}
+
+func TestNotifyFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+	ok := newWebhookRecorder()
+	defer ok.Close()
+
+	// A failing channel does not stop the others
+	s := &Server{Config: &Config{Notifiers: []NotifierConfig{
+		{Type: "webhook", Enabled: true, URL: ts.URL},
+		{Type: "webhook", Enabled: true, URL: ok.URL},
+	}}}
+	n := &NotifyService{Srv: s}
+	if err := n.sendMessage(NotifyDoorOpen, "message"); err == nil {
+		t.Error("expected an error")
+	}
+	if len(ok.Received()) != 1 {
+		t.Error("notification not sent to the working channel")
+	}
+}
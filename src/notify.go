@@ -1,91 +1,407 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
-
-	telegram "github.com/brumawen/telegram/src"
 )
 
+// ErrAlarmNotFound is returned when acknowledging an alarm that is not active
+var ErrAlarmNotFound = errors.New("alarm does not exist or the door has closed")
+
+// tempAlarmState holds the state of a temperature threshold alarm for a sensor
+type tempAlarmState struct {
+	Since  time.Time // Time the threshold was first exceeded, zero if within the threshold
+	Active bool      // Whether the alarm has been raised and not yet cleared
+}
+
+// pendingNotice holds a message queued while the notification state is locked
+type pendingNotice struct {
+	Event   string // Event that raised the message
+	Message string // Message text
+	Failed  string // Logged, followed by the error, if the message could not be sent
+}
+
 // NotifyService handles the notifications of a door left open
 type NotifyService struct {
-	WasDoor1Open bool    // Indicates that Door 1 was notified as open
-	WasDoor2Open bool    // Indeicates that Door 2 was notified as open
-	Srv          *Server // Server
+	DoorAlarms  map[int]*DoorAlarm         // Open alarm of the current open episode, by door number
+	DoorFaulted map[int]DoorStatus         // The stopped or unknown state, by door number, that was notified
+	SensorStale map[string]bool            // Indicates, by sensor name, that a sensor was notified as stale
+	TempAlarms  map[string]*tempAlarmState // Temperature threshold alarm state, by sensor name and alarm
+	Srv         *Server                    // Server
+	mu          sync.Mutex                 // Protects the notification state
+	pending     []pendingNotice            // Messages to send once the lock is released
+}
+
+// Start subscribes to the room state changes so that door state changes are notified as they happen
+func (n *NotifyService) Start() {
+	n.mu.Lock()
+	n.DoorAlarms = make(map[int]*DoorAlarm)
+	n.DoorFaulted = make(map[int]DoorStatus)
+	n.SensorStale = make(map[string]bool)
+	n.TempAlarms = make(map[string]*tempAlarmState)
+	n.mu.Unlock()
+
+	sub := n.Srv.State.Subscribe()
+	go func() {
+		for e := range sub.C {
+			switch {
+			case e.Type == EventDoor:
+				n.doorChanged(*e.Door)
+			case e.Type == EventTemperature && e.Reading != nil:
+				n.sensorChanged(*e.Reading)
+			}
+		}
+	}()
 }
 
-// Run is called from the scheduler (ClockWerk). This function will if a door has been open
-// for longer than the maximum amount of time and signal an alarm
+// Run is called from the scheduler (ClockWerk). This function will check if a door has been open
+// for longer than the maximum amount of time, or a temperature is beyond its thresholds, and signal an alarm
 func (n *NotifyService) Run() {
+	n.checkTemperatures()
+
 	config := n.Srv.Config
 	if !config.EnableDoorAlarm {
 		return
 	}
-	if config.DoorAlarmPeriod <= 0 {
-		config.DoorAlarmPeriod = 5
-	}
 
 	n.logDebug("Checking for open doors")
 
-	room := n.Srv.Room
-
-	// Check how long Door1 has been open
-	if n.Srv.Config.EnableDoor1 {
-		if room.Door1Closed {
-			n.logDebug("Door1 is closed")
-			if n.WasDoor1Open {
-				if err := n.sendMessage(fmt.Sprintf("%s's door is now closed.", room.Door1Name)); err != nil {
-					n.logError("Error notifying that door 1 is now closed. ", err.Error())
-				}
-				n.WasDoor1Open = false
+	// The messages are sent after the lock is released, so a slow notifier does not block the state changes
+	defer n.sendPending()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.checkDoors(time.Now())
+}
+
+// checkDoors queues the open alarms, reminders and escalations of the doors that are open. Must be
+// called with the lock held.
+func (n *NotifyService) checkDoors(now time.Time) {
+	config := n.Srv.Config
+
+	// Check how long each door has been open
+	for _, d := range n.Srv.State.Snapshot().Doors {
+		if !d.Enabled {
+			n.logDebug("Door", d.ID, " is disabled")
+			continue
+		}
+		if d.Closed {
+			n.logDebug("Door", d.ID, " is closed")
+			continue
+		}
+		dur := now.Sub(d.StatusTime)
+		n.logDebug("Door ", d.ID, " open for ", int(dur.Minutes()))
+
+		a := n.DoorAlarms[d.ID]
+		if a == nil || !a.Opened.Equal(d.StatusTime) {
+			a = &DoorAlarm{
+				ID:     fmt.Sprintf("%d-%d", d.ID, d.StatusTime.Unix()),
+				DoorNo: d.ID,
+				Opened: d.StatusTime,
 			}
-		} else {
-			dur := time.Since(room.Door1StatusTime)
-			n.logDebug("Door 1 open for ", int(dur.Minutes()))
-			if dur.Minutes() >= float64(config.DoorAlarmPeriod) {
-				if err := n.sendMessage(fmt.Sprintf("%s's door has been open for %d minutes.", room.Door1Name, int(dur.Minutes()))); err != nil {
-					n.logError("Error notifying that door 1 is open. ", err.Error())
-				}
-				n.WasDoor1Open = true
+			n.DoorAlarms[d.ID] = a
+		}
+		if a.Notices == 0 && !a.Snoozed {
+			// The first notice follows the alarm rules in effect now, so a door left open
+			// before night mode starts is alarmed as soon as it does
+			a.Next = d.StatusTime.Add(time.Duration(config.DoorAlarmPeriodAt(now)) * time.Minute)
+		}
+		due := !a.Acked && !now.Before(a.Next)
+
+		// Acknowledging or snoozing the reminders does not stop the escalation
+		if config.DoorAlarmEscalation > 0 && !a.Escalated && dur >= time.Duration(config.DoorAlarmEscalation)*time.Minute {
+			msg := fmt.Sprintf("%s's door has been open for %d minutes. The alarm has been escalated. Alarm %s.", d.Name, int(dur.Minutes()), a.ID)
+			n.queueAlarm(NotifyDoorEscalated, d.ID, msg, fmt.Sprint("Error escalating the open alarm of door ", d.ID, ". "))
+			a.Escalated = true
+			if a.Notices == 0 || due {
+				// The escalation takes the place of the reminder due now
+				a.Notices++
+				a.Snoozed = false
+				a.Next = now.Add(reminderDelay(config.DoorAlarmReminders, a.Notices))
 			}
+			continue
+		}
+		if !due {
+			continue
 		}
-	} else {
-		n.logDebug("Door1 is disabled")
+
+		msg := fmt.Sprintf("%s's door has been open for %d minutes. Alarm %s.", d.Name, int(dur.Minutes()), a.ID)
+		n.queueAlarm(NotifyDoorOpen, d.ID, msg, fmt.Sprint("Error notifying that door ", d.ID, " is open. "))
+		a.Notices++
+		a.Snoozed = false
+		a.Next = now.Add(reminderDelay(config.DoorAlarmReminders, a.Notices))
 	}
+}
 
-	// Check how long Door2 has been open
-	if n.Srv.Config.EnableDoor2 {
-		if room.Door2Closed {
-			n.logDebug("Door2 is closed")
-			if n.WasDoor2Open {
-				if err := n.sendMessage(fmt.Sprintf("%s's door is now closed.", room.Door2Name)); err != nil {
-					n.logError("Error notifying that door 2 is now closed. ", err.Error())
-				}
-				n.WasDoor2Open = false
-			}
+// ActiveDoorAlarms returns the open alarms of the doors that are currently open
+func (n *NotifyService) ActiveDoorAlarms() []DoorAlarm {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	lst := []DoorAlarm{}
+	for _, a := range n.DoorAlarms {
+		if a.Notices != 0 {
+			lst = append(lst, *a)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].DoorNo < lst[j].DoorNo })
+	return lst
+}
+
+// Acknowledge suppresses the reminders of the open alarm. If snooze is not zero, the
+// reminders are only suppressed for that period of time.
+func (n *NotifyService) Acknowledge(id string, snooze time.Duration) (DoorAlarm, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, a := range n.DoorAlarms {
+		if a.ID != id {
+			continue
+		}
+		if snooze > 0 {
+			a.Next = time.Now().Add(snooze)
+			a.Snoozed = true
 		} else {
-			dur := time.Since(room.Door2StatusTime)
-			n.logDebug("Door 2 open for ", int(dur.Minutes()))
-			if dur.Minutes() >= float64(config.DoorAlarmPeriod) {
-				if err := n.sendMessage(fmt.Sprintf("%s's door has been open for %d minutes.", room.Door2Name, int(dur.Minutes()))); err != nil {
-					n.logError("Error notifying that door 2 is open. ", err.Error())
-				}
-				n.WasDoor2Open = true
-			}
+			a.Acked = true
+		}
+		return *a, nil
+	}
+	return DoorAlarm{}, ErrAlarmNotFound
+}
+
+// doorChanged is called when the state of a door changes
+func (n *NotifyService) doorChanged(d DoorState) {
+	if !n.Srv.Config.EnableDoorAlarm || !d.Enabled {
+		return
+	}
+
+	defer n.sendPending()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.checkDoorState(d)
+	if a := n.DoorAlarms[d.ID]; d.Closed && a != nil {
+		if a.Notices != 0 {
+			n.queue(NotifyDoorClosed, fmt.Sprintf("%s's door is now closed.", d.Name), fmt.Sprint("Error notifying that door ", d.ID, " is now closed. "))
+		}
+		delete(n.DoorAlarms, d.ID)
+	}
+}
+
+// sensorChanged notifies when a temperature sensor stops returning valid readings and when it recovers
+func (n *NotifyService) sensorChanged(r SensorReading) {
+	defer n.sendPending()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if r.Stale == n.SensorStale[r.Name] {
+		return
+	}
+	ev := NotifySensorRecovered
+	msg := fmt.Sprintf("Temperature sensor %s is reading again.", r.Name)
+	if r.Stale {
+		ev = NotifySensorStale
+		msg = fmt.Sprintf("Temperature sensor %s has not returned a valid reading for %d minutes. %s.", r.Name, n.Srv.Config.SensorStalePeriod, r.Error)
+	}
+	n.queueSensorAlarm(ev, r.Name, msg, fmt.Sprint("Error notifying that sensor ", r.Name, " is stale. "))
+	n.SensorStale[r.Name] = r.Stale
+}
+
+// checkTemperatures raises and clears the temperature threshold alarms of the named sensors
+func (n *NotifyService) checkTemperatures() {
+	if !n.Srv.Config.EnableTempAlarm {
+		return
+	}
+
+	defer n.sendPending()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	sensors := n.Srv.State.Snapshot().Sensors
+	for _, sc := range n.Srv.Config.TempSensors {
+		r, ok := sensors[sc.Name]
+		if !ok || r.Stale || r.LastRead.IsZero() {
+			continue
+		}
+		n.checkThreshold(sc.Name, "high", sc.HighAlarm, r.Temperature, now)
+		n.checkThreshold(sc.Name, "low", sc.LowAlarm, r.Temperature, now)
+	}
+}
+
+// checkThreshold evaluates a single threshold alarm against the current temperature
+func (n *NotifyService) checkThreshold(sensor string, kind string, c TempAlarmConfig, temp float64, now time.Time) {
+	key := sensor + "/" + kind
+	if !c.Enabled {
+		delete(n.TempAlarms, key)
+		return
+	}
+	a, ok := n.TempAlarms[key]
+	if !ok {
+		a = &tempAlarmState{}
+		n.TempAlarms[key] = a
+	}
+
+	high := kind == "high"
+	if a.Active {
+		// Only clear the alarm once the temperature has moved back past the hysteresis band
+		cleared := temp <= c.Threshold-c.Hysteresis
+		if !high {
+			cleared = temp >= c.Threshold+c.Hysteresis
+		}
+		if !cleared {
+			return
+		}
+		a.Active = false
+		a.Since = time.Time{}
+		n.sendTempAlarm(NotifyTempNormal, sensor, fmt.Sprintf("%s temperature is back to normal (%.1f°C).", sensor, temp))
+		return
+	}
+
+	beyond := temp > c.Threshold
+	if !high {
+		beyond = temp < c.Threshold
+	}
+	if !beyond {
+		a.Since = time.Time{}
+		return
+	}
+	if a.Since.IsZero() {
+		a.Since = now
+	}
+	if now.Sub(a.Since) < time.Duration(c.Duration)*time.Minute {
+		return
+	}
+	a.Active = true
+	msg := fmt.Sprintf("%s is too hot (%.1f°C, above %.1f°C).", sensor, temp, c.Threshold)
+	if !high {
+		msg = fmt.Sprintf("%s is too cold (%.1f°C, below %.1f°C).", sensor, temp, c.Threshold)
+	}
+	n.sendTempAlarm(NotifyTempAlarm, sensor, msg)
+}
+
+// sendTempAlarm queues a temperature alarm message
+func (n *NotifyService) sendTempAlarm(event string, sensor string, m string) {
+	n.queueSensorAlarm(event, sensor, m, fmt.Sprint("Error sending temperature alarm for ", sensor, ". "))
+}
+
+// checkDoorState notifies when a door stops part way or its state cannot be determined
+func (n *NotifyService) checkDoorState(d DoorState) {
+	if d.State != DoorStopped && d.State != DoorUnknown {
+		delete(n.DoorFaulted, d.ID)
+		return
+	}
+	if n.DoorFaulted[d.ID] == d.State {
+		return
+	}
+	msg := fmt.Sprintf("%s's door has stopped part way.", d.Name)
+	if d.State == DoorUnknown {
+		msg = fmt.Sprintf("%s's door state is unknown. Check the door sensors.", d.Name)
+	}
+	n.queueAlarm(NotifyDoorFault, d.ID, msg, fmt.Sprint("Error notifying that door ", d.ID, " is ", d.State, ". "))
+	n.DoorFaulted[d.ID] = d.State
+}
+
+// Alarm records an alarm for the specified door and sends the message
+func (n *NotifyService) Alarm(event string, doorNo int, m string) error {
+	n.Srv.State.PublishAlarm(doorNo, m)
+	return n.sendMessage(event, m)
+}
+
+// SensorAlarm records an alarm for the specified temperature sensor and sends the message
+func (n *NotifyService) SensorAlarm(event string, sensor string, m string) error {
+	n.Srv.State.PublishSensorAlarm(sensor, m)
+	return n.sendMessage(event, m)
+}
+
+// queueAlarm records an alarm for the specified door and queues the message. Must be called with the lock held.
+func (n *NotifyService) queueAlarm(event string, doorNo int, m string, failed string) {
+	n.Srv.State.PublishAlarm(doorNo, m)
+	n.queue(event, m, failed)
+}
+
+// queueSensorAlarm records an alarm for the specified sensor and queues the message. Must be called with the lock held.
+func (n *NotifyService) queueSensorAlarm(event string, sensor string, m string, failed string) {
+	n.Srv.State.PublishSensorAlarm(sensor, m)
+	n.queue(event, m, failed)
+}
+
+// queue queues the message to be sent by sendPending. Must be called with the lock held.
+func (n *NotifyService) queue(event string, m string, failed string) {
+	n.pending = append(n.pending, pendingNotice{Event: event, Message: m, Failed: failed})
+}
+
+// sendPending sends the queued messages. Must be called without the lock held.
+func (n *NotifyService) sendPending() {
+	n.mu.Lock()
+	lst := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+
+	for _, p := range lst {
+		if err := n.sendMessage(p.Event, p.Message); err != nil {
+			n.logError(p.Failed, err.Error())
 		}
-	} else {
-		n.logDebug("Door2 is disabled")
 	}
 }
 
-// sendMessage sends the specified message to telegram
-func (n *NotifyService) sendMessage(m string) error {
-	c := telegram.Client{
-		Logger:         logger,
-		VerboseLogging: n.Srv.VerboseLogging,
+// SendSummary sends the summary report of the last day or week
+func (n *NotifyService) SendSummary(period string) error {
+	r, err := NewSummaryReport(n.Srv, period, time.Now())
+	if err != nil {
+		return err
+	}
+	return n.sendMessage(NotifySummary, r.Text(n.Srv.Config.Location()))
+}
+
+// Notify sends the message for the event without recording an alarm
+func (n *NotifyService) Notify(event string, m string) error {
+	return n.sendMessage(event, m)
+}
+
+// sendMessage sends the message for the event over the notifiers the event is routed to.
+// Telegram is used if no notifiers have been configured.
+func (n *NotifyService) sendMessage(event string, m string) error {
+	msg := Notification{Event: event, Title: "Garage", Message: m, Time: time.Now()}
+	switch event {
+	case NotifyDoorOpen, NotifyDoorEscalated, NotifyDoorFault, NotifyAutoCloseWarning, NotifyAutoCloseFailed, NotifySensorStale, NotifyTempAlarm:
+		msg.Alarm = true
+		msg.Title = "Garage alarm"
+	}
+	// A door that moved without anyone at the controls, or was opened by a guest, is always notified
+	quiet := !msg.Alarm && event != NotifyAutoClosed && event != NotifyGuestAccess
+	if quiet && n.Srv.Config.IsQuietAt(msg.Time) {
+		n.logDebug("Quiet hours, not sending ", event, " notification")
+		return nil
 	}
 
-	return c.SendMessage(m)
+	cfgs := n.Srv.Config.Notifiers
+	if len(cfgs) == 0 {
+		cfgs = []NotifierConfig{{Type: "telegram", Enabled: true}}
+	}
+	var rerr error
+	for _, c := range cfgs {
+		if !c.Routes(msg) {
+			continue
+		}
+		name := c.Name
+		if name == "" {
+			name = c.Type
+		}
+		nt, err := NewNotifier(n.Srv, c)
+		if err == nil {
+			err = nt.Send(msg)
+		}
+		if err != nil {
+			n.logError("Error sending ", event, " notification to ", name, ". ", err.Error())
+			rerr = err
+			continue
+		}
+		n.logDebug("Sent ", event, " notification to ", name)
+	}
+	return rerr
 }
 
 // logDebug logs a debug message to the logger
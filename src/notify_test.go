@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainAlarms returns the messages of the alarm events received on the subscription
+func drainAlarms(sub *Subscription) []string {
+	lst := []string{}
+	for {
+		select {
+		case e := <-sub.C:
+			if e.Type == EventAlarm {
+				lst = append(lst, e.Alarm.Message)
+			}
+		default:
+			return lst
+		}
+	}
+}
+
+func TestNotifyTempThreshold(t *testing.T) {
+	high := TempAlarmConfig{Enabled: true, Threshold: -15, Hysteresis: 2, Duration: 5}
+	low := TempAlarmConfig{Enabled: true, Threshold: 2, Hysteresis: 1}
+
+	// reading is a temperature read the specified number of minutes after the start
+	type reading struct {
+		Minute     int
+		Temp       float64
+		WantActive bool
+		WantAlarm  string // Text expected in the alarm sent after the reading, empty for none
+	}
+	tests := []struct {
+		name     string
+		kind     string
+		config   TempAlarmConfig
+		readings []reading
+	}{
+		{"raised after duration", "high", high, []reading{
+			{0, -14, false, ""},
+			{4, -13, false, ""},
+			{5, -14, true, "too hot"},
+			{6, -12, true, ""},
+		}},
+		{"brief excursion", "high", high, []reading{
+			{0, -14, false, ""},
+			{3, -16, false, ""},
+			{4, -14, false, ""},
+			{8, -14, false, ""},
+		}},
+		{"cleared past hysteresis", "high", high, []reading{
+			{0, -10, false, ""},
+			{5, -10, true, "too hot"},
+			{6, -16, true, ""},
+			{7, -17, false, "back to normal"},
+			{8, -16, false, ""},
+		}},
+		{"low immediately", "low", low, []reading{
+			{0, 1.5, true, "too cold"},
+			{1, 2.5, true, ""},
+			{2, 3, false, "back to normal"},
+		}},
+		{"disabled", "low", TempAlarmConfig{Threshold: 2}, []reading{
+			{0, -5, false, ""},
+		}},
+	}
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Config: &Config{}, State: NewStateStore()}
+			n := &NotifyService{Srv: s, TempAlarms: make(map[string]*tempAlarmState)}
+			sub := s.State.Subscribe()
+			defer sub.Close()
+
+			for _, r := range tt.readings {
+				n.checkThreshold("Freezer", tt.kind, tt.config, r.Temp, start.Add(time.Duration(r.Minute)*time.Minute))
+				active := false
+				if a, ok := n.TempAlarms["Freezer/"+tt.kind]; ok {
+					active = a.Active
+				}
+				if active != r.WantActive {
+					t.Errorf("minute %d: got active %v, want %v", r.Minute, active, r.WantActive)
+				}
+				alarms := drainAlarms(sub)
+				switch {
+				case r.WantAlarm == "" && len(alarms) != 0:
+					t.Errorf("minute %d: got alarms %q, want none", r.Minute, alarms)
+				case r.WantAlarm != "" && (len(alarms) != 1 || !strings.Contains(alarms[0], r.WantAlarm)):
+					t.Errorf("minute %d: got alarms %q, want one containing %q", r.Minute, alarms, r.WantAlarm)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyDoorReminders(t *testing.T) {
+	// step checks the open door the specified number of minutes after it was opened. The
+	// alarm is acknowledged, or snoozed for the specified number of minutes, after the check.
+	type step struct {
+		Minute    int
+		WantEvent string // Event of the message expected from the check, empty for none
+		Ack       bool
+		Snooze    int
+	}
+	tests := []struct {
+		name       string
+		escalation int
+		steps      []step
+	}{
+		{"back-off", 0, []step{
+			{4, "", false, 0},
+			{5, NotifyDoorOpen, false, 0},
+			{9, "", false, 0},
+			{10, NotifyDoorOpen, false, 0},
+			{24, "", false, 0},
+			{25, NotifyDoorOpen, false, 0},
+			{39, "", false, 0},
+			{40, NotifyDoorOpen, false, 0},
+			{55, NotifyDoorOpen, false, 0},
+		}},
+		{"acknowledged", 0, []step{
+			{5, NotifyDoorOpen, true, 0},
+			{10, "", false, 0},
+			{120, "", false, 0},
+		}},
+		{"snoozed", 0, []step{
+			{5, NotifyDoorOpen, false, 30},
+			{10, "", false, 0},
+			{35, "", false, 0},
+			{36, NotifyDoorOpen, false, 0},
+			{50, "", false, 0},
+			{51, NotifyDoorOpen, false, 0},
+		}},
+		{"escalated between reminders", 20, []step{
+			{5, NotifyDoorOpen, false, 0},
+			{10, NotifyDoorOpen, false, 0},
+			{19, "", false, 0},
+			{20, NotifyDoorEscalated, false, 0},
+			{24, "", false, 0},
+			{25, NotifyDoorOpen, false, 0},
+			{200, NotifyDoorOpen, false, 0},
+		}},
+		{"escalation replaces the reminder", 25, []step{
+			{5, NotifyDoorOpen, false, 0},
+			{10, NotifyDoorOpen, false, 0},
+			{25, NotifyDoorEscalated, false, 0},
+			{39, "", false, 0},
+			{40, NotifyDoorOpen, false, 0},
+		}},
+		{"escalated before the first notice", 3, []step{
+			{3, NotifyDoorEscalated, false, 0},
+			{5, "", false, 0},
+			{8, NotifyDoorOpen, false, 0},
+		}},
+		{"escalated after acknowledgement", 20, []step{
+			{5, NotifyDoorOpen, true, 0},
+			{10, "", false, 0},
+			{20, NotifyDoorEscalated, false, 0},
+			{25, "", false, 0},
+			{120, "", false, 0},
+		}},
+		{"escalated while snoozed", 20, []step{
+			{5, NotifyDoorOpen, false, 30},
+			{20, NotifyDoorEscalated, false, 0},
+			{25, "", false, 0},
+			{36, NotifyDoorOpen, false, 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Config: &Config{
+				EnableDoorAlarm:     true,
+				DoorAlarmPeriod:     5,
+				DoorAlarmReminders:  []int{5, 15},
+				DoorAlarmEscalation: tt.escalation,
+				Doors:               []DoorConfig{{ID: 1, Name: "Bob", Enabled: true}},
+			}, State: NewStateStore()}
+			s.State.SetDoors(s.Config.Doors)
+			// Acknowledge snoozes from the current time, so the door is opened now
+			start := time.Now()
+			s.State.UpdateDoor(1, func(d *DoorState) {
+				d.Closed = false
+				d.StatusTime = start
+			})
+			n := &NotifyService{Srv: s, DoorAlarms: make(map[int]*DoorAlarm)}
+
+			for _, st := range tt.steps {
+				now := start.Add(time.Duration(st.Minute) * time.Minute)
+				n.checkDoors(now)
+				got := n.pending
+				n.pending = nil
+				switch {
+				case st.WantEvent == "" && len(got) != 0:
+					t.Errorf("minute %d: got %+v, want no message", st.Minute, got)
+				case st.WantEvent != "" && (len(got) != 1 || got[0].Event != st.WantEvent):
+					t.Errorf("minute %d: got %+v, want one %s message", st.Minute, got, st.WantEvent)
+				case st.WantEvent == NotifyDoorEscalated && !strings.Contains(got[0].Message, "escalated"):
+					t.Errorf("minute %d: got escalation %q, want it to say it was escalated", st.Minute, got[0].Message)
+				}
+
+				if st.Ack || st.Snooze != 0 {
+					// Snooze from the time of the check rather than the time of the test
+					snooze := now.Add(time.Duration(st.Snooze) * time.Minute).Sub(time.Now())
+					if st.Ack {
+						snooze = 0
+					}
+					if _, err := n.Acknowledge(n.DoorAlarms[1].ID, snooze); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
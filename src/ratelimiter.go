@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimitWindow is the period the command rate limit applies to
+const rateLimitWindow = time.Minute
+
+// RateLimiter limits the number of commands each client can send within a sliding window
+type RateLimiter struct {
+	Srv     *Server                // Server instance
+	clients map[string][]time.Time // Times of the recent commands, by client
+	mu      sync.Mutex             // Protects the client command times
+}
+
+// Allow records a command from the client and returns whether it is within the rate limit.
+// If not, the time to wait before the next command is allowed is returned.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	return l.allowAt(client, time.Now())
+}
+
+// allowAt records a command from the client at the specified time and returns whether it is within the rate limit
+func (l *RateLimiter) allowAt(client string, now time.Time) (bool, time.Duration) {
+	limit := l.Srv.Config.CommandRateLimit
+	if limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients == nil {
+		l.clients = make(map[string][]time.Time)
+	}
+
+	recent := l.clients[client][:0]
+	for _, t := range l.clients[client] {
+		if now.Sub(t) < rateLimitWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		l.clients[client] = recent
+		return false, recent[0].Add(rateLimitWindow).Sub(now)
+	}
+	l.clients[client] = append(recent, now)
+
+	// Forget the clients that have gone quiet
+	for k, v := range l.clients {
+		if len(v) == 0 || now.Sub(v[len(v)-1]) >= rateLimitWindow {
+			delete(l.clients, k)
+		}
+	}
+	return true, 0
+}
+
+// clientKey returns the key the rate limit of the request is tracked by: the
+// authenticated principal, or the remote address for anonymous callers
+func clientKey(r *http.Request) string {
+	if p := RequestPrincipal(r); p.Name != "" && p.Name != anonymousName {
+		return p.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimit will create a handler wrapper that refuses the request with 429 Too Many Requests
+// if the client has exceeded the command rate limit
+func RateLimit(c Controller, s *Server, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := clientKey(r)
+		if ok, wait := s.RateLimiter.Allow(k); !ok {
+			c.LogInfo("Rate limit exceeded by ", k, " for ", r.Method, " ", logPath(r))
+			w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+			http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	// command is a command sent by a client, the specified number of seconds after the start
+	type command struct {
+		Client   string
+		Second   int
+		Want     bool
+		WantWait time.Duration
+	}
+	tests := []struct {
+		name     string
+		limit    int
+		commands []command
+	}{
+		{"within limit", 3, []command{
+			{"a", 0, true, 0},
+			{"a", 1, true, 0},
+			{"a", 2, true, 0},
+		}},
+		{"over limit", 2, []command{
+			{"a", 0, true, 0},
+			{"a", 10, true, 0},
+			{"a", 20, false, 40 * time.Second},
+			{"a", 30, false, 30 * time.Second},
+		}},
+		{"sliding window", 2, []command{
+			{"a", 0, true, 0},
+			{"a", 10, true, 0},
+			{"a", 59, false, time.Second},
+			{"a", 60, true, 0},
+			{"a", 65, false, 5 * time.Second},
+			{"a", 70, true, 0},
+		}},
+		{"refused commands do not count", 1, []command{
+			{"a", 0, true, 0},
+			{"a", 30, false, 30 * time.Second},
+			{"a", 60, true, 0},
+		}},
+		{"clients limited separately", 1, []command{
+			{"a", 0, true, 0},
+			{"b", 1, true, 0},
+			{"a", 2, false, 58 * time.Second},
+			{"b", 3, false, 58 * time.Second},
+		}},
+		{"no limit", -1, []command{
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+		}},
+	}
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &RateLimiter{Srv: &Server{Config: &Config{CommandRateLimit: tt.limit}}}
+			for i, c := range tt.commands {
+				ok, wait := l.allowAt(c.Client, start.Add(time.Duration(c.Second)*time.Second))
+				if ok != c.Want || wait != c.WantWait {
+					t.Errorf("command %d: got %v and wait %v, want %v and wait %v", i, ok, wait, c.Want, c.WantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		principal  *Principal
+		want       string
+	}{
+		{"remote address", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"other port", "10.0.0.2:6000", nil, "10.0.0.2"},
+		{"ipv6", "[fe80::1]:5000", nil, "fe80::1"},
+		{"no port", "10.0.0.2", nil, "10.0.0.2"},
+		{"anonymous", "10.0.0.2:5000", &Principal{Name: anonymousName, Role: RoleViewer}, "10.0.0.2"},
+		{"principal", "10.0.0.2:5000", &Principal{Name: "phone", Role: RoleOperator}, "phone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "/room/open/1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.principal != nil {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey{}, *tt.principal))
+			}
+			if got := clientKey(r); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
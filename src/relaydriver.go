@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RelayDriver defines an interface for a relay that operates a door opener
+type RelayDriver interface {
+	Pulse() error // Activates the relay for the configured pulse width
+	Close() error // Releases any resources held by the relay
+}
+
+// RelayConfig holds the configuration for a door relay
+type RelayConfig struct {
+	Type      string   `json:"type"`      // Relay type: command (default), gpio or fake
+	Command   string   `json:"command"`   // Command to run (command relays), defaults to python3
+	Args      []string `json:"args"`      // Command arguments, {door} is replaced with the door number (command relays)
+	Chip      string   `json:"chip"`      // GPIO chip, e.g. gpiochip0 (gpio relays)
+	Line      int      `json:"line"`      // GPIO line offset (gpio relays)
+	ActiveLow bool     `json:"activeLow"` // Relay is energised when the line is low (gpio relays)
+	PulseMs   int      `json:"pulseMs"`   // Time the relay is held active in milliseconds (gpio relays)
+}
+
+// NewRelayDriver creates the relay driver described by the configuration for the specified door
+func NewRelayDriver(doorNo int, c RelayConfig) (RelayDriver, error) {
+	switch c.Type {
+	case "", "command":
+		cmd := c.Command
+		args := c.Args
+		if cmd == "" {
+			cmd = "python3"
+			if len(args) == 0 {
+				args = []string{"relay.py", "{door}"}
+			}
+		}
+		a := make([]string, len(args))
+		for i, v := range args {
+			a[i] = strings.Replace(v, "{door}", strconv.Itoa(doorNo), -1)
+		}
+		return &CommandRelayDriver{Command: cmd, Args: a}, nil
+	case "gpio":
+		p := c.PulseMs
+		if p <= 0 {
+			p = 250
+		}
+		return NewGpioRelayDriver(GpioLineConfig{
+			Chip:      c.Chip,
+			Line:      c.Line,
+			ActiveLow: c.ActiveLow,
+			Output:    true,
+			Consumer:  fmt.Sprintf("garage-relay%d", doorNo),
+		}, time.Duration(p)*time.Millisecond)
+	case "fake":
+		return &FakeRelayDriver{}, nil
+	}
+	return nil, fmt.Errorf("unknown relay type '%s'", c.Type)
+}
+
+// CommandRelayDriver operates the relay by running an external command
+type CommandRelayDriver struct {
+	Command string   // Command to run
+	Args    []string // Command arguments
+}
+
+// Pulse runs the command
+func (d *CommandRelayDriver) Pulse() error {
+	// Make sure a script passed to an interpreter exists
+	if len(d.Args) != 0 && strings.HasSuffix(d.Args[0], ".py") {
+		if _, err := os.Stat(d.Args[0]); err != nil {
+			return fmt.Errorf("file %s does not exist", d.Args[0])
+		}
+	}
+	out, err := exec.Command(d.Command, d.Args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// Close releases any resources held by the relay
+func (d *CommandRelayDriver) Close() error {
+	return nil
+}
+
+// GpioRelayDriver operates a relay connected to a GPIO line
+type GpioRelayDriver struct {
+	Width time.Duration // Time the relay is held active
+	line  *gpioLine
+	mu    sync.Mutex
+}
+
+// NewGpioRelayDriver requests the GPIO line for the relay
+func NewGpioRelayDriver(c GpioLineConfig, width time.Duration) (*GpioRelayDriver, error) {
+	c.Output = true
+	l, err := requestGpioLine(c)
+	if err != nil {
+		return nil, err
+	}
+	return &GpioRelayDriver{Width: width, line: l}, nil
+}
+
+// Pulse activates the relay for the pulse width
+func (d *GpioRelayDriver) Pulse() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.line.SetValue(true); err != nil {
+		return err
+	}
+	time.Sleep(d.Width)
+	return d.line.SetValue(false)
+}
+
+// Close releases the GPIO line
+func (d *GpioRelayDriver) Close() error {
+	return d.line.Close()
+}
+
+// FakeRelayDriver is an in-memory relay driver used for testing
+type FakeRelayDriver struct {
+	Pulses  int    // Number of times the relay has been pulsed
+	Err     error  // Error to return when pulsing the relay
+	OnPulse func() // Called whenever the relay is pulsed, e.g. to change a fake sensor
+	mu      sync.Mutex
+}
+
+// Pulse records the pulse
+func (d *FakeRelayDriver) Pulse() error {
+	d.mu.Lock()
+	if d.Err != nil {
+		d.mu.Unlock()
+		return d.Err
+	}
+	d.Pulses++
+	f := d.OnPulse
+	d.mu.Unlock()
+	if f != nil {
+		f()
+	}
+	return nil
+}
+
+// Close releases any resources held by the relay
+func (d *FakeRelayDriver) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewRelayDriver(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   RelayConfig
+		wantCmd  string   // Expected command of command relays
+		wantArgs []string // Expected arguments of command relays
+		wantFake bool     // Whether a fake relay is expected
+		wantErr  bool
+	}{
+		{"default", RelayConfig{}, "python3", []string{"relay.py", "3"}, false, false},
+		{"command", RelayConfig{Type: "command", Command: "relayctl", Args: []string{"--door={door}", "pulse"}}, "relayctl", []string{"--door=3", "pulse"}, false, false},
+		{"fake", RelayConfig{Type: "fake"}, "", nil, true, false},
+		{"unknown", RelayConfig{Type: "pneumatic"}, "", nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewRelayDriver(3, tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch v := d.(type) {
+			case *CommandRelayDriver:
+				if v.Command != tt.wantCmd || !reflect.DeepEqual(v.Args, tt.wantArgs) {
+					t.Errorf("got %s %v, want %s %v", v.Command, v.Args, tt.wantCmd, tt.wantArgs)
+				}
+			case *FakeRelayDriver:
+				if !tt.wantFake {
+					t.Error("got a fake relay")
+				}
+			default:
+				t.Errorf("unexpected relay type %T", d)
+			}
+		})
+	}
+}
+
+func TestFakeRelayDriver(t *testing.T) {
+	s := &FakeDoorSensor{Closed: true}
+	d := &FakeRelayDriver{OnPulse: func() { s.Set(false) }}
+	if err := d.Pulse(); err != nil {
+		t.Fatal(err)
+	}
+	if closed, _ := s.IsClosed(); closed || d.Pulses != 1 {
+		t.Errorf("got closed %v after %d pulses, want open after 1", closed, d.Pulses)
+	}
+
+	d.Err = errors.New("relay error")
+	if err := d.Pulse(); err != d.Err {
+		t.Errorf("got error %v, want %v", err, d.Err)
+	}
+	if d.Pulses != 1 {
+		t.Errorf("got %d pulses, want a failed pulse to not be counted", d.Pulses)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ReportController handles the Web Methods for the activity reports.
+type ReportController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *ReportController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/reports/summary").Name("GetSummaryReport").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetSummary))))
+}
+
+// handleGetSummary returns the summary report for the period query value (day, the default, or week)
+func (c *ReportController) handleGetSummary(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("period")
+	if p == "" {
+		p = ReportDay
+	}
+	rpt, err := NewSummaryReport(c.Srv, p, time.Now())
+	switch {
+	case errors.Is(err, ErrInvalidPeriod):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		c.LogError("Error building the summary report. ", err.Error())
+		http.Error(w, "Error building the summary report", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, rpt)
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *ReportController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("ReportController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *ReportController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("ReportController: [Err] ", a)
+}
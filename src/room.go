@@ -8,14 +8,60 @@ import (
 
 // Room holds the information about the room being monitored
 type Room struct {
-	Door1Name       string    `json:"door1name"`       // Name of garage door 1
-	Door1Closed     bool      `json:"door1closed"`     // Whether door 1 is closed
-	Door2Name       string    `json:"door2name"`       // Name of garage door 2
-	Door2Closed     bool      `json:"door2closed"`     // Whether door 2 is closed
-	Temperature     float64   `json:"temp"`            // Room temperature
-	LastRead        time.Time `json:"lastread"`        // Time the values were last read
-	Door1StatusTime time.Time `json:"door1statustime"` // Time that Door1 status was set
-	Door2StatusTime time.Time `json:"door2statustime"` // Time that Door2 status was set
+	Doors       []DoorState              `json:"doors"`    // State of the garage doors
+	Temperature float64                  `json:"temp"`     // Room temperature
+	Sensors     map[string]SensorReading `json:"sensors"`  // Temperature sensor readings by sensor name
+	LastRead    time.Time                `json:"lastread"` // Time the values were last read
+}
+
+// DoorState holds the state of a single garage door
+type DoorState struct {
+	ID         int        `json:"id"`         // Door number
+	Name       string     `json:"name"`       // Name of the garage door
+	Enabled    bool       `json:"enabled"`    // Whether the door is enabled
+	Closed     bool       `json:"closed"`     // Whether the door is closed
+	StatusTime time.Time  `json:"statustime"` // Time that the door status was set
+	State      DoorStatus `json:"state"`      // Travel state of the door
+	StateTime  time.Time  `json:"statetime"`  // Time that the travel state was entered
+}
+
+// SensorReading holds the last reading of a one-wire temperature sensor
+type SensorReading struct {
+	ID          string    `json:"id"`              // One-wire device ID
+	Name        string    `json:"name"`            // Sensor name, or the device ID if the sensor has not been named
+	Named       bool      `json:"named"`           // Whether the sensor has been named in the configuration
+	Temperature float64   `json:"temp"`            // Last valid temperature
+	LastRead    time.Time `json:"lastread"`        // Time the last valid temperature was read
+	Stale       bool      `json:"stale"`           // No valid reading has been obtained within the stale period
+	Error       string    `json:"error,omitempty"` // Reason the last reading was rejected or failed
+}
+
+// SetDoors synchronizes the list of doors with the configured doors, keeping
+// the current state of doors that already exist
+func (r *Room) SetDoors(doors []DoorConfig) {
+	lst := []DoorState{}
+	for _, dc := range doors {
+		d := DoorState{State: DoorUnknown}
+		if e := r.Door(dc.ID); e != nil {
+			d = *e
+		}
+		d.ID = dc.ID
+		d.Name = dc.Name
+		d.Enabled = dc.Enabled
+		lst = append(lst, d)
+	}
+	r.Doors = lst
+}
+
+// Door returns the state of the door with the specified number.
+// Nil is returned if the door does not exist.
+func (r *Room) Door(id int) *DoorState {
+	for i := range r.Doors {
+		if r.Doors[i].ID == id {
+			return &r.Doors[i]
+		}
+	}
+	return nil
 }
 
 // WriteTo serializes the entity and writes it to the http response
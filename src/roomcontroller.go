@@ -1,13 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// sseHeartbeat is the interval at which a heartbeat is sent on an idle event stream
+const sseHeartbeat = 15 * time.Second
+
 // RoomController handles the Web Methods for the room being monitored
 type RoomController struct {
 	Srv *Server
@@ -17,53 +23,168 @@ type RoomController struct {
 func (c *RoomController) AddController(router *mux.Router, s *Server) {
 	c.Srv = s
 	router.Methods("GET").Path("/room/get").Name("GetTelemetry").
-		Handler(Logger(c, http.HandlerFunc(c.handleGetTelemetry)))
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetTelemetry))))
+	router.Methods("GET").Path("/room/events").Name("GetEvents").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleEvents))))
 	router.Methods("POST").Path("/room/update").Name("UpdateTelemetry").
-		Handler(Logger(c, http.HandlerFunc(c.handleUpdate)))
+		Handler(AuthorizeLocal(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleUpdate))))
 	router.Methods("POST").Path("/room/open/{doorNo}").Name("OpenDoor").
-		Handler(Logger(c, http.HandlerFunc(c.handleOpenDoor)))
+		Handler(Authorize(c, s, RoleOperator, RateLimit(c, s, Logger(c, http.HandlerFunc(c.handleOpenDoor)))))
+	router.Methods("POST").Path("/room/door/{doorNo}/autoclose/cancel").Name("CancelAutoClose").
+		Handler(Authorize(c, s, RoleOperator, Logger(c, http.HandlerFunc(c.handleCancelAutoClose))))
+	router.Methods("POST").Path("/room/door/{doorNo}/{action:open|close|toggle}").Name("DoorCommand").
+		Handler(Authorize(c, s, RoleOperator, RateLimit(c, s, Logger(c, http.HandlerFunc(c.handleDoorCommand)))))
 }
 
-// handlerGetTelemetry will return the current telemetry for the room
+// handlerGetTelemetry will return the current telemetry for the room. The snapshot of the room
+// state is served, the sensors are read on the upload schedule rather than on every request.
 func (c *RoomController) handleGetTelemetry(w http.ResponseWriter, r *http.Request) {
-	if err := c.Srv.RoomService.UpdateTelemetry(); err != nil {
-		http.Error(w, "Error updating telemetry", http.StatusInternalServerError)
+	room := c.Srv.State.Snapshot()
+	if err := room.WriteTo(w); err != nil {
+		c.LogError("Error serializing telemetry. ", err.Error())
+		http.Error(w, "Error serializing telemetry", http.StatusInternalServerError)
+	}
+}
+
+// handleEvents streams the room state changes to the client as Server-Sent Events.  A client
+// reconnecting with a Last-Event-ID header receives the events it missed, otherwise the
+// stream starts with a snapshot of the room.
+func (c *RoomController) handleEvents(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	resume := err == nil
+	sub, missed, complete := c.Srv.State.SubscribeFrom(lastID)
+	defer sub.Close()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if resume && complete {
+		for _, e := range missed {
+			c.writeEvent(w, e)
+		}
 	} else {
-		if err := c.Srv.Room.WriteTo(w); err != nil {
-			c.LogError("Error serializing telemetry. ", err.Error())
-			http.Error(w, "Error serializing telemetry", http.StatusInternalServerError)
+		room := c.Srv.State.Snapshot()
+		b, _ := json.Marshal(room)
+		fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", b)
+	}
+	f.Flush()
+
+	hb := time.NewTicker(sseHeartbeat)
+	defer hb.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			c.writeEvent(w, e)
+		case t := <-hb.C:
+			fmt.Fprintf(w, ": heartbeat %s\n\n", t.UTC().Format(time.RFC3339))
+		case <-r.Context().Done():
+			return
 		}
+		f.Flush()
+	}
+}
+
+// writeEvent writes the state event to the Server-Sent Events stream
+func (c *RoomController) writeEvent(w http.ResponseWriter, e StateEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		c.LogError("Error serializing event. ", err.Error())
+		return
 	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
 }
 
 // handleUpdate is called from the python script monitoring the door switches.  This call tells
-// the server that the door status has changed
+// the server that the door status has changed.  The DoorWatcher normally picks up the change
+// first, this remains as a fallback.  Only telemetry is sent, so viewers and local callers
+// are allowed.
 func (c *RoomController) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	c.Srv.SendTelemetry()
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleOpenDoor pulses the door relay.  This is kept for existing clients and behaves as a toggle.
 func (c *RoomController) handleOpenDoor(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	d := vars["doorNo"]
-	doorNo := 0
-	if d != "" {
-		if i, err := strconv.Atoi(d); err == nil {
-			doorNo = i
-		}
-	}
-	if doorNo < 1 || doorNo > 2 {
+	doorNo := c.getDoorNo(r)
+	if dc := c.Srv.Config.Door(doorNo); dc == nil || !dc.Enabled {
 		c.LogError("Invalid door number")
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	err := c.Srv.RoomService.OpenDoor(doorNo)
-	if err != nil {
-		http.Error(w, "Failed", http.StatusInternalServerError)
-	} else {
-		w.WriteHeader(http.StatusNoContent)
+	c.writeCommandResult(w, c.Srv.RoomService.ToggleDoor(doorNo, CommandOrigin{Source: SourceRest, RemoteAddr: r.RemoteAddr, Principal: RequestPrincipal(r).Name}))
+}
+
+// handleDoorCommand opens, closes or toggles the door and waits for it to reach the new state
+func (c *RoomController) handleDoorCommand(w http.ResponseWriter, r *http.Request) {
+	o := CommandOrigin{Source: SourceRest, RemoteAddr: r.RemoteAddr, Principal: RequestPrincipal(r).Name}
+	err := c.Srv.RoomService.DoorCommand(c.getDoorNo(r), mux.Vars(r)["action"], o)
+	c.writeCommandResult(w, err)
+}
+
+// handleCancelAutoClose cancels the pending auto-close of the door
+func (c *RoomController) handleCancelAutoClose(w http.ResponseWriter, r *http.Request) {
+	o := CommandOrigin{Source: SourceRest, RemoteAddr: r.RemoteAddr, Principal: RequestPrincipal(r).Name}
+	if err := c.Srv.AutoCloser.Cancel(c.getDoorNo(r), o); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeCommandResult writes the result of a door command to the response
+func (c *RoomController) writeCommandResult(w http.ResponseWriter, err error) {
+	st := commandStatus(err)
+	switch {
+	case err == nil:
+		w.WriteHeader(st)
+	case st == http.StatusInternalServerError:
+		c.LogError("Door command failed. ", err.Error())
+		http.Error(w, "Failed. "+err.Error(), st)
+	default:
+		http.Error(w, err.Error(), st)
+	}
+}
+
+// commandStatus returns the HTTP status code for the result of a door command
+func commandStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusNoContent
+	case errors.Is(err, ErrDoorNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidAction):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrDoorAlreadyOpen), errors.Is(err, ErrDoorAlreadyClosed), errors.Is(err, ErrDoorMoving), errors.Is(err, ErrDoorBusy):
+		return http.StatusConflict
+	case errors.Is(err, ErrDoorCooldown), errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrDoorTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// getDoorNo returns the door number from the request path
+func (c *RoomController) getDoorNo(r *http.Request) int {
+	doorNo := 0
+	if d := mux.Vars(r)["doorNo"]; d != "" {
+		if i, err := strconv.Atoi(d); err == nil {
+			doorNo = i
+		}
 	}
+	return doorNo
 }
 
 // LogInfo is used to log information messages for this controller.
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetTelemetrySnapshot(t *testing.T) {
+	r, _, _ := newTestRoomService(true, false)
+	read := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.Srv.State.SetTemperature(21.5, read)
+	r.Srv.State.SetLastRead(read)
+	router := mux.NewRouter()
+	c := &RoomController{}
+	c.AddController(router, r.Srv)
+
+	// The sensors are not read, so the request succeeds without a one-wire bus
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/room/get", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	room := Room{}
+	if err := json.Unmarshal(w.Body.Bytes(), &room); err != nil {
+		t.Fatal(err)
+	}
+	if room.Temperature != 21.5 || !room.LastRead.Equal(read) || len(room.Doors) != 1 {
+		t.Errorf("got %+v, want the state snapshot", room)
+	}
+}
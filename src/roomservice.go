@@ -3,47 +3,418 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
 	"path"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	gopitools "github.com/brumawen/gopi-tools/src"
 )
 
+// doorPollInterval is the interval at which the door sensor is read while waiting for a door to move
+const doorPollInterval = 250 * time.Millisecond
+
+// Errors returned by the door commands
+var (
+	ErrDoorNotFound      = errors.New("door does not exist or is disabled")
+	ErrDoorAlreadyOpen   = errors.New("door is already open")
+	ErrDoorAlreadyClosed = errors.New("door is already closed")
+	ErrDoorMoving        = errors.New("door is already moving in the requested direction")
+	ErrDoorTimeout       = errors.New("door did not reach the requested state")
+	ErrInvalidAction     = errors.New("invalid door action")
+	ErrDoorBusy          = errors.New("another command is in progress for the door")
+	ErrDoorCooldown      = errors.New("door relay was pulsed too recently")
+	ErrRateLimited       = errors.New("too many commands, try again later")
+)
+
 // RoomService contains service methods for the room being monitored
 type RoomService struct {
-	Srv *Server
+	Srv         *Server                   // Server instance
+	sensors     map[int]DoorSensor        // Door closed sensors by door number
+	openSensors map[int]DoorSensor        // Door open-limit sensors by door number
+	relays      map[int]RelayDriver       // Door opener relays by door number
+	machines    map[int]*doorMachine      // Door travel state machines by door number
+	timers      map[int]*time.Timer       // Travel timeout timers by door number
+	mu          sync.Mutex                // Serializes the door status updates
+	devices     []gopitools.OneWireDevice // One-wire devices found on the last read
+	devMu       sync.Mutex                // Protects the device list
+	filters     map[string]*sensorFilter  // Temperature sensor filters by one-wire device ID
+	filterMu    sync.Mutex                // Protects the temperature sensor filters
+	inFlight    map[int]bool              // Doors with a command in progress, by door number
+	lastPulse   map[int]time.Time         // Time the relay was last pulsed, by door number
+	cmdMu       sync.Mutex                // Protects the command interlock state
 }
 
-// OpenDoor issues the command to open the specified door number
-func (r *RoomService) OpenDoor(doorNo int) error {
-	if _, err := os.Stat("relay.py"); err != nil {
-		r.logError("File relay.py does not exist.")
+// Initialize creates the sensors and relays for the configured doors
+func (r *RoomService) Initialize() error {
+	r.Close()
+	r.sensors = make(map[int]DoorSensor)
+	r.openSensors = make(map[int]DoorSensor)
+	r.relays = make(map[int]RelayDriver)
+	r.machines = make(map[int]*doorMachine)
+	r.timers = make(map[int]*time.Timer)
+	var rerr error
+	for _, d := range r.Srv.Config.Doors {
+		if !d.Enabled {
+			continue
+		}
+		s, err := NewDoorSensor(d.ID, d.Sensor)
+		if err != nil {
+			r.logError("Error creating sensor for door ", d.ID, ". ", err.Error())
+			rerr = err
+			continue
+		}
+		if ws, ok := s.(WatchableSensor); ok {
+			if err := ws.Watch(r.Srv.SendTelemetry); err != nil {
+				r.logError("Error watching sensor for door ", d.ID, ". ", err.Error())
+			}
+		}
+		r.sensors[d.ID] = s
+
+		if d.OpenSensor != nil {
+			oc := *d.OpenSensor
+			if (oc.Type == "" || oc.Type == "file") && oc.Path == "" {
+				oc.Path = path.Join("data", fmt.Sprintf("door%d.open.state", d.ID))
+			}
+			lim, err := NewDoorSensor(d.ID, oc)
+			if err != nil {
+				r.logError("Error creating open sensor for door ", d.ID, ". ", err.Error())
+				rerr = err
+			} else {
+				if ws, ok := lim.(WatchableSensor); ok {
+					if err := ws.Watch(r.Srv.SendTelemetry); err != nil {
+						r.logError("Error watching open sensor for door ", d.ID, ". ", err.Error())
+					}
+				}
+				r.openSensors[d.ID] = lim
+			}
+		}
+		r.machines[d.ID] = newDoorMachine(time.Duration(d.TravelTimeout)*time.Second, r.openSensors[d.ID] != nil)
+
+		rd, err := NewRelayDriver(d.ID, d.Relay)
+		if err != nil {
+			r.logError("Error creating relay for door ", d.ID, ". ", err.Error())
+			rerr = err
+			continue
+		}
+		r.relays[d.ID] = rd
+	}
+	return rerr
+}
+
+// Close releases the door sensors and relays
+func (r *RoomService) Close() {
+	for id, s := range r.sensors {
+		if err := s.Close(); err != nil {
+			r.logError("Error closing sensor for door ", id, ". ", err.Error())
+		}
+	}
+	r.sensors = nil
+	for id, s := range r.openSensors {
+		if err := s.Close(); err != nil {
+			r.logError("Error closing open sensor for door ", id, ". ", err.Error())
+		}
+	}
+	r.openSensors = nil
+	r.mu.Lock()
+	for _, t := range r.timers {
+		t.Stop()
+	}
+	r.mu.Unlock()
+	for id, rd := range r.relays {
+		if err := rd.Close(); err != nil {
+			r.logError("Error closing relay for door ", id, ". ", err.Error())
+		}
+	}
+	r.relays = nil
+}
+
+// Sources of door commands
+const (
+	SourceRest      = "rest"      // REST web methods
+	SourceMqtt      = "mqtt"      // MQTT set topics
+	SourceWebSocket = "websocket" // WebSocket clients
+	SourceSchedule  = "schedule"  // Scheduled actions
+	SourceAutoClose = "autoclose" // Doors closed automatically after being left open
+	SourceExternal  = "external"  // Doors moved outside this service, e.g. by a wall button or remote
+	SourceGuest     = "guest"     // Guest passes
+)
+
+// CommandOrigin identifies where a door command came from
+type CommandOrigin struct {
+	Source     string `json:"source"`               // Channel the command arrived on
+	RemoteAddr string `json:"remoteAddr,omitempty"` // Address of the caller, if any
+	Principal  string `json:"principal,omitempty"`  // Authenticated caller, if any
+}
+
+// DoorCommand performs the open, close or toggle action on the specified door
+func (r *RoomService) DoorCommand(doorNo int, action string, o CommandOrigin) error {
+	if dc := r.Srv.Config.Door(doorNo); dc == nil || !dc.Enabled {
+		r.logError("Invalid door number ", doorNo)
+		r.audit(doorNo, action, o, ErrDoorNotFound)
+		return ErrDoorNotFound
+	}
+	switch action {
+	case "open":
+		return r.OpenDoor(doorNo, o)
+	case "close":
+		return r.CloseDoor(doorNo, o)
+	case "toggle":
+		return r.ToggleDoor(doorNo, o)
+	}
+	return ErrInvalidAction
+}
+
+// OpenDoor opens the specified door and waits for the sensor to report that it is open
+func (r *RoomService) OpenDoor(doorNo int, o CommandOrigin) error {
+	r.logInfo("Open door ", doorNo, " requested from ", o.Source, " ", o.RemoteAddr)
+	err := r.interlock(doorNo, func() error { return r.moveDoor(doorNo, false) })
+	r.Srv.State.PublishCommand(doorNo, "open", o, err)
+	r.audit(doorNo, "open", o, err)
+	return err
+}
+
+// CloseDoor closes the specified door and waits for the sensor to report that it is closed
+func (r *RoomService) CloseDoor(doorNo int, o CommandOrigin) error {
+	r.logInfo("Close door ", doorNo, " requested from ", o.Source, " ", o.RemoteAddr)
+	err := r.interlock(doorNo, func() error { return r.moveDoor(doorNo, true) })
+	r.Srv.State.PublishCommand(doorNo, "close", o, err)
+	r.audit(doorNo, "close", o, err)
+	return err
+}
+
+// ToggleDoor pulses the relay of the specified door and waits for the sensor to
+// report that the door has changed state
+func (r *RoomService) ToggleDoor(doorNo int, o CommandOrigin) error {
+	r.logInfo("Toggle door ", doorNo, " requested from ", o.Source, " ", o.RemoteAddr)
+	err := r.interlock(doorNo, func() error { return r.toggleDoor(doorNo) })
+	r.Srv.State.PublishCommand(doorNo, "toggle", o, err)
+	r.audit(doorNo, "toggle", o, err)
+	return err
+}
+
+// interlock runs the door command unless another command is already in progress for the door
+func (r *RoomService) interlock(doorNo int, cmd func() error) error {
+	r.cmdMu.Lock()
+	if r.inFlight == nil {
+		r.inFlight = make(map[int]bool)
+	}
+	if r.inFlight[doorNo] {
+		r.cmdMu.Unlock()
+		r.logInfo("Door", doorNo, " command refused. A command is already in progress.")
+		return ErrDoorBusy
+	}
+	r.inFlight[doorNo] = true
+	r.cmdMu.Unlock()
+
+	defer func() {
+		r.cmdMu.Lock()
+		delete(r.inFlight, doorNo)
+		r.cmdMu.Unlock()
+	}()
+	return cmd()
+}
+
+// audit records the door command in the audit log
+func (r *RoomService) audit(doorNo int, action string, o CommandOrigin, cmdErr error) {
+	if r.Srv.Audit == nil {
+		return
+	}
+	if err := r.Srv.Audit.Record(doorNo, action, o, cmdErr); err != nil {
+		r.logError("Error recording door", doorNo, " ", action, " command in the audit log. ", err.Error())
+	}
+}
+
+// toggleDoor pulses the relay and waits for the door to change state
+func (r *RoomService) toggleDoor(doorNo int) error {
+	s, err := r.sensor(doorNo)
+	if err != nil {
 		return err
 	}
+	closed, err := s.IsClosed()
+	if err != nil {
+		r.logError("Failed to read door", doorNo, " state. ", err.Error())
+		return err
+	}
+	if err := r.pulseRelay(doorNo); err != nil {
+		return err
+	}
+	return r.waitForState(doorNo, !closed)
+}
 
-	out, err := exec.Command("python3", "relay.py", strconv.Itoa(doorNo)).CombinedOutput()
+// moveDoor moves the door to the closed or open state, refusing the command if the door
+// is already in that state or moving towards it. A door moving the other way, or stopped
+// part way, is pulsed as many times as needed to set it moving in the requested direction.
+func (r *RoomService) moveDoor(doorNo int, close bool) error {
+	s, err := r.sensor(doorNo)
 	if err != nil {
-		r.logError("Failed to open door ", doorNo, ". ", err.Error())
-		msg := string(out)
-		return errors.New(msg)
+		return err
 	}
-	return err
+	closed, err := s.IsClosed()
+	if err != nil {
+		r.logError("Failed to read door", doorNo, " state. ", err.Error())
+		return err
+	}
+
+	open := false
+	lim, hasOpenSensor := r.openSensors[doorNo]
+	if hasOpenSensor {
+		if open, err = lim.IsClosed(); err != nil {
+			r.logError("Failed to read door", doorNo, " open state. ", err.Error())
+			return err
+		}
+	}
+
+	// Bring the travel state up to date with the sensors before deciding how to move the door
+	st := DoorUnknown
+	m, hasMachine := r.machines[doorNo]
+	if hasMachine {
+		st = m.Update(closed, open, nil, time.Now())
+	}
+	// Without an open-limit sensor the door is taken to be fully open once it is not closed
+	// and not travelling or stopped part way
+	fullyOpen := !closed && (open || !hasOpenSensor && (st == DoorOpen || st == DoorUnknown))
+	dir := DoorOpening
+	if close {
+		dir = DoorClosing
+	}
+	switch {
+	case close && closed:
+		return ErrDoorAlreadyClosed
+	case !close && fullyOpen:
+		return ErrDoorAlreadyOpen
+	case st == dir:
+		return ErrDoorMoving
+	}
+
+	pulses := 1
+	if hasMachine {
+		pulses = m.PulsesTo(dir)
+	}
+	for i := 0; i < pulses; i++ {
+		if i != 0 {
+			// Let the door settle between the pulses
+			r.logInfo("Door", doorNo, " is not ", dir, ". Pulsing the relay again.")
+			time.Sleep(r.minPulseInterval(doorNo))
+		}
+		if err := r.pulseRelay(doorNo); err != nil {
+			return err
+		}
+	}
+	return r.waitForState(doorNo, close)
+}
+
+// waitForState waits for the door sensors to report the target state within the
+// travel timeout of the door. A notification is sent if the door does not reach it.
+// Doors with an open-limit sensor are only considered open once that sensor is active.
+func (r *RoomService) waitForState(doorNo int, closed bool) error {
+	action, target := "open", "open"
+	if closed {
+		action, target = "close", "closed"
+	}
+	timeout := 30 * time.Second
+	name := fmt.Sprintf("Door %d", doorNo)
+	if dc := r.Srv.Config.Door(doorNo); dc != nil {
+		timeout = time.Duration(dc.TravelTimeout) * time.Second
+		name = dc.Name
+	}
+
+	r.logInfo("Waiting up to ", timeout, " for door", doorNo, " to be ", target)
+	end := time.Now().Add(timeout)
+	for {
+		if r.isInState(doorNo, closed) {
+			r.logInfo("Door", doorNo, " is ", target)
+			r.Srv.SendTelemetry()
+			return nil
+		}
+		if time.Now().After(end) {
+			break
+		}
+		time.Sleep(doorPollInterval)
+	}
+
+	r.logError("Door", doorNo, " did not reach the ", target, " state within ", timeout)
+	r.Srv.SendTelemetry()
+	if err := r.Srv.NotifyService.Alarm(NotifyDoorFault, doorNo, fmt.Sprintf("%s's door failed to %s. It was not %s after %d seconds.",
+		name, action, target, int(timeout.Seconds()))); err != nil {
+		r.logError("Error notifying that door ", doorNo, " failed to ", action, ". ", err.Error())
+	}
+	return fmt.Errorf("%w: door %d was not %s after %s", ErrDoorTimeout, doorNo, target, timeout)
 }
 
-// UpdateTelemetry will update all telemetry associated with the room
+// pulseRelay pulses the relay of the specified door
+func (r *RoomService) pulseRelay(doorNo int) error {
+	rd, ok := r.relays[doorNo]
+	if !ok {
+		r.logError("No relay available for door ", doorNo)
+		return ErrDoorNotFound
+	}
+
+	min := r.minPulseInterval(doorNo)
+	r.cmdMu.Lock()
+	if r.lastPulse == nil {
+		r.lastPulse = make(map[int]time.Time)
+	}
+	if since := time.Since(r.lastPulse[doorNo]); since < min {
+		r.cmdMu.Unlock()
+		r.logInfo("Relay for door ", doorNo, " not pulsed. It was pulsed ", since.Round(time.Millisecond), " ago.")
+		return ErrDoorCooldown
+	}
+	r.lastPulse[doorNo] = time.Now()
+	r.cmdMu.Unlock()
+
+	r.logInfo("Pulsing relay for door ", doorNo)
+	if err := rd.Pulse(); err != nil {
+		r.logError("Failed to pulse the relay for door ", doorNo, ". ", err.Error())
+		return err
+	}
+	if m, ok := r.machines[doorNo]; ok {
+		st := m.Pulse(time.Now())
+		r.logInfo("Door", doorNo, " is ", st)
+		r.Srv.SendTelemetry()
+	}
+	return nil
+}
+
+// minPulseInterval returns the minimum time between the relay pulses of the door
+func (r *RoomService) minPulseInterval(doorNo int) time.Duration {
+	if dc := r.Srv.Config.Door(doorNo); dc != nil {
+		return time.Duration(dc.MinPulseInterval) * time.Second
+	}
+	return 3 * time.Second
+}
+
+// isInState returns whether the door sensors report the door as closed, or open
+func (r *RoomService) isInState(doorNo int, closed bool) bool {
+	c, err := r.sensors[doorNo].IsClosed()
+	if err != nil {
+		return false
+	}
+	if closed || c {
+		return c == closed
+	}
+	if lim, ok := r.openSensors[doorNo]; ok {
+		o, err := lim.IsClosed()
+		return err == nil && o
+	}
+	return true
+}
+
+// sensor returns the sensor of the specified door
+func (r *RoomService) sensor(doorNo int) (DoorSensor, error) {
+	s, ok := r.sensors[doorNo]
+	if !ok {
+		r.logError("No sensor available for door ", doorNo)
+		return nil, ErrDoorNotFound
+	}
+	return s, nil
+}
+
+// UpdateTelemetry will update all telemetry associated with the room. All one-wire
+// temperature sensors are read. The first named sensor, or the first sensor found if
+// none have been named, provides the room temperature.
 func (r *RoomService) UpdateTelemetry() error {
 	// Update the last read time
-	r.Srv.Room.LastRead = time.Now().UTC()
-
-	// Get the temperature probe
-	tmp := gopitools.OneWireTemp{}
-	defer tmp.Close()
-	tmp.ID = ""
+	r.Srv.State.SetLastRead(time.Now().UTC())
 
 	// Get the available one-wire devices
 	r.logDebug("Getting one-wire device list.")
@@ -51,102 +422,224 @@ func (r *RoomService) UpdateTelemetry() error {
 	if err != nil {
 		msg := "Error getting one-wire device list. " + err.Error() + "."
 		r.logError(msg)
+		// Mark the sensors as failed, so they go stale if the bus stays down
+		r.updateMissingSensors(nil, err)
 		return err
 	}
+	r.devMu.Lock()
+	r.devices = devlst
+	r.devMu.Unlock()
 	if len(devlst) == 0 {
 		msg := "No temperature device found. Cable could be disconnected."
 		r.logError(msg)
-	} else {
-		r.logDebug("Reading temperature from ", devlst[0].Name)
-		tmp.ID = devlst[0].ID
+	}
+
+	primary := ""
+	if len(devlst) != 0 {
+		primary = devlst[0].ID
+	}
+	if len(r.Srv.Config.TempSensors) != 0 {
+		primary = r.Srv.Config.TempSensors[0].ID
+	}
+
+	var rerr error
+	found := map[string]bool{}
+	for _, dev := range devlst {
+		found[dev.ID] = true
+		r.logDebug("Reading temperature from ", dev.Name)
+		tmp := gopitools.OneWireTemp{}
+		tmp.ID = dev.ID
 		temp, err := tmp.ReadTemp()
+		tmp.Close()
 		if err != nil {
-			msg := "Error reading temperature. " + err.Error() + "."
-			r.logError(msg)
-			return err
+			rerr = err
 		}
-		r.Srv.Room.Temperature = temp
+		r.updateSensor(dev.ID, dev.ID == primary, temp, err)
 	}
+	r.updateMissingSensors(found, errors.New("sensor not found"))
 
-	return nil
+	return rerr
 }
 
-// UpdateDoorStatus will update the Room telemetry with the new door statuses
-func (r *RoomService) UpdateDoorStatus() error {
-	r.logInfo("Updating door status")
-	wd, err := os.Getwd()
+// updateMissingSensors updates the configured and previously read sensors that
+// were not found with the error, so that they are marked stale in time
+func (r *RoomService) updateMissingSensors(found map[string]bool, err error) {
+	if found == nil {
+		found = map[string]bool{}
+	}
+	for _, sc := range r.Srv.Config.TempSensors {
+		if !found[sc.ID] {
+			found[sc.ID] = true
+			r.updateSensor(sc.ID, false, 0, err)
+		}
+	}
+	r.filterMu.Lock()
+	ids := []string{}
+	for id := range r.filters {
+		if !found[id] {
+			ids = append(ids, id)
+		}
+	}
+	r.filterMu.Unlock()
+	for _, id := range ids {
+		r.updateSensor(id, false, 0, err)
+	}
+}
+
+// updateSensor filters the temperature read from a sensor and updates the room state,
+// MQTT and temperature history with the accepted value. Rejected or failed readings
+// keep the last valid temperature and mark the sensor stale once the stale period has passed.
+func (r *RoomService) updateSensor(id string, primary bool, temp float64, err error) {
+	rd := SensorReading{ID: id, Name: id}
+	sc := r.Srv.Config.TempSensor(id)
+	if sc != nil {
+		rd.Name = sc.Name
+		rd.Named = true
+	}
+	if old, ok := r.Srv.State.Snapshot().Sensors[rd.Name]; ok {
+		rd.Temperature = old.Temperature
+		rd.LastRead = old.LastRead
+	}
+
+	now := time.Now().UTC()
+	r.filterMu.Lock()
+	f := r.sensorFilter(id, sc)
+	if err == nil {
+		temp, err = f.Apply(temp, now)
+	}
+	stale := err != nil && f.IsStale(now, time.Duration(r.Srv.Config.SensorStalePeriod)*time.Minute)
+	r.filterMu.Unlock()
 	if err != nil {
-		r.logError("Error getting current working directory. ", err.Error())
-		return err
+		r.logError("Error reading temperature from ", rd.Name, ". ", err.Error(), ".")
+		rd.Error = err.Error()
+		rd.Stale = stale
+		r.Srv.State.SetSensor(rd)
+		return
 	}
-	dp := path.Join(wd, "data")
+	rd.Temperature = temp
+	rd.LastRead = now
 
-	// Get Door 1 State
-	if r.Srv.Config.EnableDoor1 {
-		if d1, err := r.readFileContents(path.Join(dp, "door1.state")); err != nil {
-			r.logError("Failed to read door1 state. ", err)
-		} else {
-			r.logDebug("Read door1 state as ", d1)
-			if strings.Contains(d1, "closed") {
-				if !r.Srv.Room.Door1Closed {
-					r.Srv.Room.Door1Closed = true
-					r.Srv.Room.Door1StatusTime = time.Now()
-				}
-				r.logDebug("Door1 is closed")
-			} else {
-				if r.Srv.Room.Door1Closed {
-					r.Srv.Room.Door1Closed = false
-					r.Srv.Room.Door1StatusTime = time.Now()
-				}
-				r.logInfo("Door1 is open")
-			}
+	r.Srv.State.SetSensor(rd)
+	if primary {
+		r.Srv.State.SetTemperature(temp, rd.LastRead)
+	}
+	if r.Srv.TempHistory != nil {
+		if err := r.Srv.TempHistory.Record(rd.Name, temp, rd.LastRead); err != nil {
+			r.logError("Error recording temperature. ", err.Error())
+		}
+	}
+}
+
+// sensorFilter returns the filter for the sensor, creating it afresh if the
+// sensor calibration settings have changed. The caller must hold filterMu.
+func (r *RoomService) sensorFilter(id string, sc *TempSensorConfig) *sensorFilter {
+	c := TempSensorConfig{ID: id}
+	if sc != nil {
+		c = *sc
+	}
+	if r.filters == nil {
+		r.filters = make(map[string]*sensorFilter)
+	}
+	f, ok := r.filters[id]
+	if !ok || f.config != c {
+		n := newSensorFilter(sc)
+		if ok {
+			n.LastValid = f.LastValid
 		}
-	} else {
-		r.Srv.Room.Door1Closed = true
-		r.Srv.Room.Door1StatusTime = time.Now()
-		r.logInfo("Door1 is disabled")
+		n.config = c
+		r.filters[id] = n
+		f = n
 	}
+	return f
+}
 
-	// Get Door 2 State
-	if r.Srv.Config.EnableDoor2 {
-		if d2, err := r.readFileContents(path.Join(dp, "door2.state")); err != nil {
-			r.logError("Failed to read door2 state. ", err)
-		} else {
-			r.logDebug("Read door2 state as ", d2)
-			if strings.Contains(d2, "closed") {
-				if !r.Srv.Room.Door2Closed {
-					r.Srv.Room.Door2Closed = true
-					r.Srv.Room.Door2StatusTime = time.Now()
-				}
-				r.logDebug("Door2 is closed")
-			} else {
-				if r.Srv.Room.Door2Closed {
-					r.Srv.Room.Door2Closed = false
-					r.Srv.Room.Door2StatusTime = time.Now()
+// PrimarySensor returns the name of the sensor that provides the room temperature
+func (r *RoomService) PrimarySensor() string {
+	if len(r.Srv.Config.TempSensors) != 0 {
+		return r.Srv.Config.TempSensors[0].Name
+	}
+	if d := r.Devices(); len(d) != 0 {
+		return d[0].ID
+	}
+	return ""
+}
+
+// Devices returns the one-wire devices found when the temperatures were last read
+func (r *RoomService) Devices() []gopitools.OneWireDevice {
+	r.devMu.Lock()
+	defer r.devMu.Unlock()
+	return append([]gopitools.OneWireDevice{}, r.devices...)
+}
+
+// UpdateDoorStatus will update the Room telemetry with the new door statuses
+func (r *RoomService) UpdateDoorStatus() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logInfo("Updating door status")
+	now := time.Now()
+	for _, d := range r.Srv.State.Snapshot().Doors {
+		if !d.Enabled {
+			r.Srv.State.UpdateDoor(d.ID, func(d *DoorState) {
+				if !d.Closed || d.State != DoorClosed {
+					d.Closed = true
+					d.StatusTime = now
+					d.State = DoorClosed
+					d.StateTime = now
 				}
-				r.logDebug("Door2 is open")
+			})
+			r.logInfo("Door", d.ID, " is disabled")
+			continue
+		}
+		s, ok := r.sensors[d.ID]
+		if !ok {
+			r.logError("No sensor available for door", d.ID)
+			continue
+		}
+		closed, err := s.IsClosed()
+		if err != nil {
+			r.logError("Failed to read door", d.ID, " state. ", err)
+		} else if closed {
+			r.logDebug("Door", d.ID, " is closed")
+		} else {
+			r.logInfo("Door", d.ID, " is open")
+		}
+
+		open := false
+		if lim, ok := r.openSensors[d.ID]; ok && err == nil {
+			if open, err = lim.IsClosed(); err != nil {
+				r.logError("Failed to read door", d.ID, " open state. ", err)
+			}
+		}
+
+		m := r.machines[d.ID]
+		st := m.Update(closed, open, err, now)
+		_, since := m.Status()
+		n, changed := r.Srv.State.UpdateDoor(d.ID, func(d *DoorState) {
+			if err == nil && d.Closed != closed {
+				d.Closed = closed
+				d.StatusTime = now
 			}
+			d.State = st
+			d.StateTime = since
+		})
+		if changed && n.State != d.State {
+			r.logInfo("Door", d.ID, " state changed from ", d.State, " to ", n.State)
+		}
+		if st.IsMoving() {
+			r.scheduleUpdate(d.ID, m.Travel)
 		}
-	} else {
-		r.Srv.Room.Door2Closed = true
-		r.Srv.Room.Door2StatusTime = time.Now()
-		r.logInfo("Door2 is disabled")
 	}
 	return nil
 }
 
-func (r *RoomService) readFileContents(filePath string) (dir string, err error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	b, err := ioutil.ReadAll(file)
-	if err != nil {
-		return "", err
+// scheduleUpdate re-evaluates the door state once the door should have finished
+// moving, so that a door that never reaches its limit switch is reported as stopped
+func (r *RoomService) scheduleUpdate(doorNo int, travel time.Duration) {
+	if t, ok := r.timers[doorNo]; ok {
+		t.Stop()
 	}
-	return string(b), nil
+	r.timers[doorNo] = time.AfterFunc(travel+time.Second, r.Srv.SendTelemetry)
 }
 
 // logDebug logs a debug message to the logger
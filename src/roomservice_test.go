@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRoomService creates a room service for a single door with a fake sensor and relay.
+// Pulsing the relay moves the door unless stuck is set.
+func newTestRoomService(closed bool, stuck bool) (*RoomService, *FakeDoorSensor, *FakeRelayDriver) {
+	s := &Server{Config: &Config{Doors: []DoorConfig{{
+		ID:            1,
+		Enabled:       true,
+		TravelTimeout: 1,
+		Sensor:        SensorConfig{Type: "fake"},
+		Relay:         RelayConfig{Type: "fake"},
+	}}}}
+	s.Config.SetDefaults()
+	s.State = NewStateStore()
+	s.State.SetDoors(s.Config.Doors)
+	s.Uploader.Srv = s
+	s.NotifyService.Srv = s
+	s.MqttClient = &Mqtt{Srv: s}
+	s.RateLimiter.Srv = s
+	r := &RoomService{Srv: s}
+	s.RoomService = r
+	r.Initialize()
+
+	sensor := r.sensors[1].(*FakeDoorSensor)
+	sensor.Closed = closed
+	relay := r.relays[1].(*FakeRelayDriver)
+	if !stuck {
+		// The door leaves or reaches the closed switch shortly after the relay is pulsed
+		relay.OnPulse = func() {
+			c, _ := sensor.IsClosed()
+			time.AfterFunc(10*time.Millisecond, func() { sensor.Set(!c) })
+		}
+	}
+	return r, sensor, relay
+}
+
+func TestRoomServiceDoorCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		closed     bool
+		stuck      bool
+		command    func(r *RoomService, doorNo int, o CommandOrigin) error
+		doorNo     int
+		wantErr    error
+		wantPulses int
+		wantClosed bool
+	}{
+		{"open closed door", true, false, (*RoomService).OpenDoor, 1, nil, 1, false},
+		{"open open door", false, false, (*RoomService).OpenDoor, 1, ErrDoorAlreadyOpen, 0, false},
+		{"close open door", false, false, (*RoomService).CloseDoor, 1, nil, 1, true},
+		{"close closed door", true, false, (*RoomService).CloseDoor, 1, ErrDoorAlreadyClosed, 0, true},
+		{"toggle closed door", true, false, (*RoomService).ToggleDoor, 1, nil, 1, false},
+		{"toggle open door", false, false, (*RoomService).ToggleDoor, 1, nil, 1, true},
+		{"unknown door", true, false, (*RoomService).OpenDoor, 2, ErrDoorNotFound, 0, true},
+		{"stuck door", true, true, (*RoomService).OpenDoor, 1, ErrDoorTimeout, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, sensor, relay := newTestRoomService(tt.closed, tt.stuck)
+			err := tt.command(r, tt.doorNo, CommandOrigin{Source: SourceRest})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if relay.Pulses != tt.wantPulses {
+				t.Errorf("got %d pulses, want %d", relay.Pulses, tt.wantPulses)
+			}
+			if closed, _ := sensor.IsClosed(); closed != tt.wantClosed {
+				t.Errorf("got closed %v, want %v", closed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestRoomServiceConcurrentSensorUpdates(t *testing.T) {
+	r, _, _ := newTestRoomService(true, false)
+	r.Srv.Config.TempSensors = []TempSensorConfig{{ID: "28-1", Name: "Freezer", Average: 3}}
+
+	// Telemetry is updated concurrently by the web methods, Thingspeak and the senders
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				r.updateSensor("28-1", true, -18, nil)
+				r.updateSensor(fmt.Sprintf("28-%d", i+2), false, 20, nil)
+				r.updateMissingSensors(map[string]bool{"28-1": true}, errors.New("sensor not found"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := r.Srv.State.Snapshot().Sensors["Freezer"].Temperature; got != -18 {
+		t.Errorf("got temperature %.2f, want -18", got)
+	}
+}
+
+func TestRoomServiceMoveDoorTravelState(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     DoorStatus
+		direction  DoorStatus
+		close      bool
+		wantErr    error
+		wantPulses int
+	}{
+		{"open closed door", DoorClosed, DoorClosing, false, nil, 1},
+		{"open while closing", DoorClosing, DoorClosing, false, nil, 2},
+		{"open while opening", DoorOpening, DoorOpening, false, ErrDoorMoving, 0},
+		{"open door stopped while closing", DoorStopped, DoorClosing, false, nil, 1},
+		{"open door stopped while opening", DoorStopped, DoorOpening, false, nil, 3},
+		{"open fully open door", DoorOpen, DoorOpening, false, ErrDoorAlreadyOpen, 0},
+		{"close open door", DoorOpen, DoorOpening, true, nil, 1},
+		{"close while opening", DoorOpening, DoorOpening, true, nil, 2},
+		{"close while closing", DoorClosing, DoorClosing, true, ErrDoorMoving, 0},
+		{"close door stopped while opening", DoorStopped, DoorOpening, true, nil, 1},
+		{"close closed door", DoorClosed, DoorClosing, true, ErrDoorAlreadyClosed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, sensor, relay := newTestRoomService(tt.status == DoorClosed, true)
+			r.Srv.Config.Doors[0].MinPulseInterval = 0
+			open := &FakeDoorSensor{Closed: tt.status == DoorOpen}
+			r.openSensors[1] = open
+			m := r.machines[1]
+			m.HasOpenSensor = true
+			m.mu.Lock()
+			m.status = tt.status
+			m.direction = tt.direction
+			m.since = time.Now()
+			m.mu.Unlock()
+
+			// The door reaches the end of its travel as soon as it starts moving
+			relay.OnPulse = func() {
+				m.mu.Lock()
+				sim := &doorMachine{status: m.status, direction: m.direction}
+				m.mu.Unlock()
+				switch sim.Pulse(time.Now()) {
+				case DoorOpening:
+					sensor.Set(false)
+					open.Set(true)
+				case DoorClosing:
+					open.Set(false)
+					sensor.Set(true)
+				}
+			}
+
+			cmd := (*RoomService).OpenDoor
+			if tt.close {
+				cmd = (*RoomService).CloseDoor
+			}
+			if err := cmd(r, 1, CommandOrigin{Source: SourceRest}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if relay.Pulses != tt.wantPulses {
+				t.Errorf("got %d pulses, want %d", relay.Pulses, tt.wantPulses)
+			}
+		})
+	}
+}
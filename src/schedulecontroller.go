@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ScheduleController handles the Web Methods for managing the user-defined schedules.
+type ScheduleController struct {
+	Srv *Server
+}
+
+// AddController adds the controller routes to the router
+func (c *ScheduleController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/schedules").Name("GetSchedules").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetSchedules))))
+	router.Methods("POST").Path("/schedules/reload").Name("ReloadSchedules").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleReloadSchedules))))
+	router.Methods("POST").Path("/schedules").Name("SaveSchedule").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleSaveSchedule))))
+	router.Methods("DELETE").Path("/schedules/{id}").Name("DeleteSchedule").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleDeleteSchedule))))
+}
+
+// handleGetSchedules returns the configured schedules
+func (c *ScheduleController) handleGetSchedules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.Srv.Scheduler.Schedules())
+}
+
+// handleSaveSchedule creates the schedule in the JSON body, or updates it if it has an ID
+func (c *ScheduleController) handleSaveSchedule(w http.ResponseWriter, r *http.Request) {
+	sc := ScheduleConfig{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+		http.Error(w, "Invalid schedule. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sc, err := c.Srv.Scheduler.Save(sc)
+	switch {
+	case errors.Is(err, ErrScheduleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInvalidSchedule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		c.LogError("Error saving schedule ", sc.ID, ". ", err.Error())
+		http.Error(w, "Error saving configuration", http.StatusInternalServerError)
+		return
+	}
+	c.LogInfo("Schedule ", sc.ID, " (", sc.Name, ") saved")
+	writeJSON(w, sc)
+}
+
+// handleDeleteSchedule deletes the schedule
+func (c *ScheduleController) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := c.Srv.Scheduler.Delete(id)
+	switch {
+	case errors.Is(err, ErrScheduleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		c.LogError("Error deleting schedule ", id, ". ", err.Error())
+		http.Error(w, "Error saving configuration", http.StatusInternalServerError)
+		return
+	}
+	c.LogInfo("Schedule ", id, " deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReloadSchedules reloads the schedules from the configuration file
+func (c *ScheduleController) handleReloadSchedules(w http.ResponseWriter, r *http.Request) {
+	if err := c.Srv.Scheduler.ReloadFromFile(); err != nil {
+		c.LogError("Error reloading schedules. ", err.Error())
+		http.Error(w, "Error reading configuration", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *ScheduleController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("ScheduleController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *ScheduleController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("ScheduleController: [Err] ", a)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduled actions
+const (
+	ScheduleOpen        = "open"        // Open the door
+	ScheduleClose       = "close"       // Close the door
+	ScheduleToggle      = "toggle"      // Toggle the door
+	ScheduleTemperature = "temperature" // Send the current temperatures
+	ScheduleReport      = "report"      // Send the summary report
+)
+
+// Schedule errors
+var (
+	ErrScheduleNotFound = errors.New("schedule does not exist")
+	ErrInvalidSchedule  = errors.New("invalid schedule")
+)
+
+// ScheduleConfig holds a user-defined scheduled action
+type ScheduleConfig struct {
+	ID      string `json:"id"`      // Schedule ID, generated when the schedule is created
+	Name    string `json:"name"`    // Description, e.g. close door 2 at night
+	Cron    string `json:"cron"`    // Standard cron expression (minute hour day month weekday) in the configured time zone
+	Action  string `json:"action"`  // Action: open, close, toggle, temperature or report
+	Period  string `json:"period"`  // Summary report period: day or week (report actions)
+	DoorNo  int    `json:"door"`    // Door number (door actions)
+	OnlyIf  string `json:"onlyIf"`  // Only run the door action if the door is open or closed (empty to always run)
+	Enabled bool   `json:"enabled"` // Enable the schedule
+}
+
+// Schedule parses the cron expression. The times are matched in the time zone of the time passed to Next.
+func (c *ScheduleConfig) Schedule() (cron.Schedule, error) {
+	return cron.ParseStandard(c.Cron)
+}
+
+// Validate checks that the schedule can be run
+func (c *ScheduleConfig) Validate(cfg *Config) error {
+	if _, err := c.Schedule(); err != nil {
+		return fmt.Errorf("invalid cron expression. %s", err.Error())
+	}
+	switch c.Action {
+	case ScheduleOpen, ScheduleClose, ScheduleToggle:
+		if dc := cfg.Door(c.DoorNo); dc == nil || !dc.Enabled {
+			return ErrDoorNotFound
+		}
+	case ScheduleTemperature:
+	case ScheduleReport:
+		if c.Period != ReportDay && c.Period != ReportWeek {
+			return ErrInvalidPeriod
+		}
+	default:
+		return fmt.Errorf("invalid action '%s'", c.Action)
+	}
+	if c.OnlyIf != "" && c.OnlyIf != "open" && c.OnlyIf != "closed" {
+		return fmt.Errorf("invalid condition '%s'", c.OnlyIf)
+	}
+	return nil
+}
+
+// Scheduler runs the user-defined schedules held in the configuration
+type Scheduler struct {
+	Srv  *Server    // Server instance
+	cron *cron.Cron // Cron scheduler
+	mu   sync.Mutex // Protects the scheduler and the configured schedules
+}
+
+// Start schedules the configured schedules
+func (s *Scheduler) Start() {
+	s.Reload()
+}
+
+// Reload stops the running schedules and schedules the configured schedules afresh
+func (s *Scheduler) Reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	s.cron = cron.New(cron.WithLocation(s.Srv.Config.Location()))
+	for _, sc := range s.Srv.Config.Schedules {
+		if !sc.Enabled {
+			continue
+		}
+		if err := sc.Validate(s.Srv.Config); err != nil {
+			s.logError("Schedule ", sc.ID, " (", sc.Name, ") is invalid. ", err.Error())
+			continue
+		}
+		sched, _ := sc.Schedule()
+		sc := sc
+		s.cron.Schedule(sched, cron.FuncJob(func() { s.run(sc) }))
+	}
+	s.cron.Start()
+	s.logInfo(len(s.cron.Entries()), " schedules loaded")
+}
+
+// Close stops the running schedules
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cron != nil {
+		s.cron.Stop()
+		s.cron = nil
+	}
+}
+
+// Schedules returns the configured schedules
+func (s *Scheduler) Schedules() []ScheduleConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lst := append([]ScheduleConfig{}, s.Srv.Config.Schedules...)
+	sort.Slice(lst, func(i, j int) bool { return lst[i].Name < lst[j].Name })
+	return lst
+}
+
+// Save adds the schedule, or replaces the schedule with the same ID, saves the
+// configuration and reloads the schedules
+func (s *Scheduler) Save(sc ScheduleConfig) (ScheduleConfig, error) {
+	if err := sc.Validate(s.Srv.Config); err != nil {
+		return sc, fmt.Errorf("%w. %s", ErrInvalidSchedule, err.Error())
+	}
+
+	s.mu.Lock()
+	cfg := s.Srv.Config
+	if sc.ID == "" {
+		sc.ID = newID()
+		cfg.Schedules = append(cfg.Schedules, sc)
+	} else {
+		i := s.index(sc.ID)
+		if i < 0 {
+			s.mu.Unlock()
+			return sc, ErrScheduleNotFound
+		}
+		cfg.Schedules[i] = sc
+	}
+	err := cfg.WriteToFile("config.json")
+	s.mu.Unlock()
+
+	s.Reload()
+	return sc, err
+}
+
+// Delete removes the schedule, saves the configuration and reloads the schedules
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	cfg := s.Srv.Config
+	i := s.index(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return ErrScheduleNotFound
+	}
+	cfg.Schedules = append(cfg.Schedules[:i:i], cfg.Schedules[i+1:]...)
+	err := cfg.WriteToFile("config.json")
+	s.mu.Unlock()
+
+	s.Reload()
+	return err
+}
+
+// ReloadFromFile reads the schedules from the configuration file, so that changes
+// made to the file take effect, and reloads them
+func (s *Scheduler) ReloadFromFile() error {
+	c := Config{}
+	if err := c.ReadFromFile("config.json"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.Srv.Config.Schedules = c.Schedules
+	s.mu.Unlock()
+
+	s.Reload()
+	return nil
+}
+
+// index returns the index of the schedule in the configuration, or -1 if it does not exist
+func (s *Scheduler) index(id string) int {
+	for i, sc := range s.Srv.Config.Schedules {
+		if sc.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// run runs the scheduled action
+func (s *Scheduler) run(sc ScheduleConfig) {
+	s.logInfo("Running schedule ", sc.ID, " (", sc.Name, ")")
+	switch sc.Action {
+	case ScheduleTemperature:
+		s.sendTemperatures()
+		return
+	case ScheduleReport:
+		if err := s.Srv.NotifyService.SendSummary(sc.Period); err != nil {
+			s.logError("Error sending the ", sc.Period, " summary report. ", err.Error())
+		}
+		return
+	}
+
+	if sc.OnlyIf != "" {
+		d, ok := s.Srv.State.Door(sc.DoorNo)
+		if !ok || d.Closed != (sc.OnlyIf == "closed") {
+			s.logInfo("Door", sc.DoorNo, " is not ", sc.OnlyIf, ". Schedule ", sc.ID, " skipped.")
+			return
+		}
+	}
+	err := s.Srv.RoomService.DoorCommand(sc.DoorNo, sc.Action, CommandOrigin{Source: SourceSchedule, RemoteAddr: sc.ID})
+	if errors.Is(err, ErrDoorAlreadyOpen) || errors.Is(err, ErrDoorAlreadyClosed) || errors.Is(err, ErrDoorMoving) {
+		s.logInfo("Schedule ", sc.ID, " door command ignored. ", err.Error())
+	} else if err != nil {
+		s.logError("Schedule ", sc.ID, " door command failed. ", err.Error())
+	}
+}
+
+// sendTemperatures sends the current temperature of each sensor
+func (s *Scheduler) sendTemperatures() {
+	room := s.Srv.State.Snapshot()
+	names := []string{}
+	for n := range room.Sensors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	lines := []string{fmt.Sprintf("Garage temperature is %.1f°C.", room.Temperature)}
+	for _, n := range names {
+		r := room.Sensors[n]
+		l := fmt.Sprintf("%s: %.1f°C", n, r.Temperature)
+		if r.Stale {
+			l += " (stale)"
+		}
+		lines = append(lines, l)
+	}
+	if err := s.Srv.NotifyService.Notify(NotifyScheduled, strings.Join(lines, "\n")); err != nil {
+		s.logError("Error sending the temperatures. ", err.Error())
+	}
+}
+
+// newID returns a new random ID, used for schedules and guest passes
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logInfo logs an information message to the logger
+func (s *Scheduler) logInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("Scheduler: [Inf] ", a)
+}
+
+// logError logs an error message to the logger
+func (s *Scheduler) logError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("Scheduler: [Err] ", a)
+}
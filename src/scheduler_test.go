@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	loc, err := time.LoadLocation("Africa/Johannesburg")
+	if err != nil {
+		t.Skip("time zone database not available. ", err)
+	}
+	at := func(month time.Month, day int, hour int, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, loc)
+	}
+
+	// The 2nd of January 2026 is a Friday
+	tests := []struct {
+		name  string
+		cron  string
+		after time.Time
+		want  time.Time
+	}{
+		{"nightly", "0 22 * * *", at(1, 2, 12, 0), at(1, 2, 22, 0)},
+		{"nightly at the time", "0 22 * * *", at(1, 2, 22, 0), at(1, 3, 22, 0)},
+		{"weekdays", "30 6 * * 1-5", at(1, 2, 7, 0), at(1, 5, 6, 30)},
+		{"weekend", "0 9 * * sat,sun", at(1, 5, 0, 0), at(1, 10, 9, 0)},
+		{"every 15 minutes", "*/15 * * * *", at(1, 2, 10, 7), at(1, 2, 10, 15)},
+		{"monthly", "0 0 1 * *", at(1, 15, 0, 0), at(2, 1, 0, 0)},
+		{"descriptor", "@daily", at(1, 2, 12, 0), at(1, 3, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := ScheduleConfig{Cron: tt.cron}
+			sched, err := sc.Schedule()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The scheduler passes times in the configured time zone, so the expression is matched in it
+			if got := sched.Next(tt.after.UTC().In(loc)); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	cfg := &Config{Doors: []DoorConfig{{ID: 1, Enabled: true}, {ID: 2, Enabled: false}}}
+	tests := []struct {
+		name    string
+		sc      ScheduleConfig
+		wantErr bool
+	}{
+		{"close door", ScheduleConfig{Cron: "0 22 * * *", Action: ScheduleClose, DoorNo: 1}, false},
+		{"close if open", ScheduleConfig{Cron: "0 22 * * *", Action: ScheduleClose, DoorNo: 1, OnlyIf: "open"}, false},
+		{"temperature", ScheduleConfig{Cron: "0 8 * * *", Action: ScheduleTemperature}, false},
+		{"weekly report", ScheduleConfig{Cron: "0 8 * * mon", Action: ScheduleReport, Period: ReportWeek}, false},
+		{"missing field", ScheduleConfig{Cron: "0 22 * *", Action: ScheduleClose, DoorNo: 1}, true},
+		{"seconds field", ScheduleConfig{Cron: "0 0 22 * * *", Action: ScheduleClose, DoorNo: 1}, true},
+		{"minute out of range", ScheduleConfig{Cron: "61 * * * *", Action: ScheduleClose, DoorNo: 1}, true},
+		{"unknown door", ScheduleConfig{Cron: "0 22 * * *", Action: ScheduleClose, DoorNo: 3}, true},
+		{"disabled door", ScheduleConfig{Cron: "0 22 * * *", Action: ScheduleClose, DoorNo: 2}, true},
+		{"report period", ScheduleConfig{Cron: "0 8 * * *", Action: ScheduleReport, Period: "month"}, true},
+		{"unknown action", ScheduleConfig{Cron: "0 8 * * *", Action: "dance"}, true},
+		{"unknown condition", ScheduleConfig{Cron: "0 22 * * *", Action: ScheduleClose, DoorNo: 1, OnlyIf: "ajar"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sc.Validate(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// sensorNamePattern restricts sensor names to values that are safe to use in MQTT topics
+var sensorNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SensorController handles the Web Methods for listing and naming the temperature sensors.
+type SensorController struct {
+	Srv *Server
+}
+
+// SensorInfo describes a one-wire temperature sensor
+type SensorInfo struct {
+	ID          string  `json:"id"`             // One-wire device ID
+	Name        string  `json:"name,omitempty"` // Configured name
+	Found       bool    `json:"found"`          // Whether the sensor was found on the last read
+	Temperature float64 `json:"temp"`           // Last temperature read
+}
+
+// AddController adds the controller routes to the router
+func (c *SensorController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/sensors").Name("GetSensors").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleGetSensors))))
+	router.Methods("POST").Path("/sensors/{id}").Name("SetSensorName").
+		Handler(Authorize(c, s, RoleAdmin, Logger(c, http.HandlerFunc(c.handleSetSensorName))))
+}
+
+// handleGetSensors returns the configured sensors and any unnamed sensors that have been discovered
+func (c *SensorController) handleGetSensors(w http.ResponseWriter, r *http.Request) {
+	room := c.Srv.State.Snapshot()
+	found := map[string]bool{}
+	for _, d := range c.Srv.RoomService.Devices() {
+		found[d.ID] = true
+	}
+
+	lst := []SensorInfo{}
+	for _, sc := range c.Srv.Config.TempSensors {
+		lst = append(lst, SensorInfo{ID: sc.ID, Name: sc.Name, Found: found[sc.ID], Temperature: room.Sensors[sc.Name].Temperature})
+		delete(found, sc.ID)
+	}
+	unnamed := []string{}
+	for id := range found {
+		unnamed = append(unnamed, id)
+	}
+	sort.Strings(unnamed)
+	for _, id := range unnamed {
+		lst = append(lst, SensorInfo{ID: id, Found: true, Temperature: room.Sensors[id].Temperature})
+	}
+	writeJSON(w, lst)
+}
+
+// handleSetSensorName names the sensor with the name form value. An empty name removes the name.
+func (c *SensorController) handleSetSensorName(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id := mux.Vars(r)["id"]
+	name := r.Form.Get("name")
+
+	if name != "" && !sensorNamePattern.MatchString(name) {
+		http.Error(w, "Name may only contain letters, digits, underscores and dashes", http.StatusBadRequest)
+		return
+	}
+	for _, sc := range c.Srv.Config.TempSensors {
+		if name != "" && sc.Name == name && sc.ID != id {
+			http.Error(w, "Name is already used by sensor "+sc.ID, http.StatusConflict)
+			return
+		}
+	}
+
+	cfg := c.Srv.Config
+	if sc := cfg.TempSensor(id); sc != nil {
+		if name == "" {
+			lst := []TempSensorConfig{}
+			for _, v := range cfg.TempSensors {
+				if v.ID != id {
+					lst = append(lst, v)
+				}
+			}
+			cfg.TempSensors = lst
+		} else {
+			sc.Name = name
+		}
+	} else if name != "" {
+		cfg.TempSensors = append(cfg.TempSensors, TempSensorConfig{ID: id, Name: name})
+	}
+
+	c.LogInfo("Sensor ", id, " named '", name, "'")
+	if err := cfg.WriteToFile("config.json"); err != nil {
+		c.LogError("Error saving configuration. ", err.Error())
+		http.Error(w, "Error saving configuration", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *SensorController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("SensorController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *SensorController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("SensorController: [Err] ", a)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestSensorRouter returns a router for the sensor controller of a server with a named freezer sensor
+func newTestSensorRouter() (*mux.Router, *Server) {
+	s := &Server{
+		Config: &Config{
+			TempSensors: []TempSensorConfig{{ID: "28-1", Name: "Freezer"}},
+			Auth:        AuthConfig{AnonymousRole: RoleAdmin},
+		},
+		State:       NewStateStore(),
+		RoomService: &RoomService{},
+	}
+	s.RoomService.Srv = s
+	router := mux.NewRouter()
+	c := &SensorController{}
+	c.AddController(router, s)
+	return router, s
+}
+
+func TestGetSensors(t *testing.T) {
+	router, s := newTestSensorRouter()
+	s.State.SetSensor(SensorReading{ID: "28-1", Name: "Freezer", Named: true, Temperature: -18})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/sensors", nil))
+	lst := []SensorInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &lst); err != nil {
+		t.Fatal(err)
+	}
+	if len(lst) != 1 || lst[0].ID != "28-1" || lst[0].Name != "Freezer" || lst[0].Found || lst[0].Temperature != -18 {
+		t.Errorf("got %+v, want the freezer sensor, not found on the last read", lst)
+	}
+}
+
+func TestSetSensorNameRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		sensorName string
+		wantStatus int
+	}{
+		{"space", "28-2", "Deep Freezer", http.StatusBadRequest},
+		{"topic separator", "28-2", "garage/freezer", http.StatusBadRequest},
+		{"wildcard", "28-2", "freezer#", http.StatusBadRequest},
+		{"name in use", "28-2", "Freezer", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, s := newTestSensorRouter()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/sensors/"+tt.id, strings.NewReader(url.Values{"name": {tt.sensorName}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			router.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(s.Config.TempSensors) != 1 || s.Config.TempSensor("28-1").Name != "Freezer" {
+				t.Errorf("configuration changed to %+v", s.Config.TempSensors)
+			}
+		})
+	}
+}
+
+func TestRoomServicePrimarySensor(t *testing.T) {
+	tests := []struct {
+		name    string
+		sensors []TempSensorConfig
+		want    string
+	}{
+		{"first named", []TempSensorConfig{{ID: "28-2", Name: "Room"}, {ID: "28-1", Name: "Freezer"}}, "Room"},
+		{"none named or found", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RoomService{Srv: &Server{Config: &Config{TempSensors: tt.sensors}}}
+			if got := r.PrimarySensor(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
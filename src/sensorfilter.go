@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// sensorSpikeLimit is the number of consecutive spike rejections after which the
+// new level is accepted as a genuine change
+const sensorSpikeLimit = 3
+
+// sensorFilter calibrates the raw readings of a temperature sensor and rejects the
+// readings that are out of range or change faster than is physically plausible
+type sensorFilter struct {
+	Offset   float64 // Added to the scaled reading
+	Scale    float64 // Raw reading multiplier
+	Min      float64 // Lowest valid calibrated reading
+	Max      float64 // Highest valid calibrated reading
+	MaxDelta float64 // Maximum change per minute, 0 to disable
+	Average  int     // Number of readings in the moving average, 0 or 1 to disable
+
+	config    TempSensorConfig // Configuration the filter was created from
+	last      float64          // Last accepted calibrated reading
+	lastTime  time.Time        // Time of the last accepted reading
+	spikes    int              // Number of consecutive spike rejections
+	window    []float64        // Readings in the moving average
+	LastValid time.Time        // Time of the last accepted reading, or the time the filter was created
+}
+
+// newSensorFilter creates the filter for the sensor configuration. A nil configuration uses the defaults.
+func newSensorFilter(c *TempSensorConfig) *sensorFilter {
+	f := &sensorFilter{Scale: 1, Min: -55, Max: 125, MaxDelta: 10, LastValid: time.Now()}
+	if c == nil {
+		return f
+	}
+	f.Offset = c.Offset
+	if c.Scale != 0 {
+		f.Scale = c.Scale
+	}
+	if c.MinTemp != 0 {
+		f.Min = c.MinTemp
+	}
+	if c.MaxTemp != 0 {
+		f.Max = c.MaxTemp
+	}
+	if c.MaxDeltaPerMinute != 0 {
+		f.MaxDelta = c.MaxDeltaPerMinute
+	}
+	f.Average = c.Average
+	return f
+}
+
+// Apply calibrates and validates the raw reading. Returns the value to publish,
+// or an error if the reading was rejected.
+func (f *sensorFilter) Apply(raw float64, now time.Time) (float64, error) {
+	v := raw*f.Scale + f.Offset
+
+	// A DS18B20 returns 85 on power-on reset and -127 when it cannot be read
+	if raw == 85 || raw == -127 || v < f.Min || v > f.Max {
+		return 0, fmt.Errorf("reading %.2f is out of range", raw)
+	}
+
+	if f.MaxDelta > 0 && !f.lastTime.IsZero() {
+		mins := math.Max(now.Sub(f.lastTime).Minutes(), 1)
+		if math.Abs(v-f.last) > f.MaxDelta*mins {
+			f.spikes++
+			if f.spikes < sensorSpikeLimit {
+				return 0, fmt.Errorf("reading %.2f changed by more than %.1f per minute", v, f.MaxDelta)
+			}
+			// The level has persisted, accept it and start averaging afresh
+			f.window = nil
+		}
+	}
+
+	f.spikes = 0
+	f.last = v
+	f.lastTime = now
+	f.LastValid = now
+
+	if f.Average <= 1 {
+		return v, nil
+	}
+	f.window = append(f.window, v)
+	if len(f.window) > f.Average {
+		f.window = f.window[len(f.window)-f.Average:]
+	}
+	sum := 0.0
+	for _, w := range f.window {
+		sum += w
+	}
+	return sum / float64(len(f.window)), nil
+}
+
+// IsStale returns whether no valid reading has been accepted within the period
+func (f *sensorFilter) IsStale(now time.Time, period time.Duration) bool {
+	return now.Sub(f.LastValid) > period
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSensorFilterApply(t *testing.T) {
+	// reading is a raw reading, taken the specified number of minutes after the start
+	type reading struct {
+		Minute  float64
+		Raw     float64
+		Want    float64
+		WantErr bool
+	}
+	tests := []struct {
+		name     string
+		config   *TempSensorConfig
+		readings []reading
+	}{
+		{"defaults", nil, []reading{
+			{0, 21.5, 21.5, false},
+			{1, 22, 22, false},
+		}},
+		{"calibration", &TempSensorConfig{Offset: -0.5, Scale: 2}, []reading{
+			{0, 10, 19.5, false},
+		}},
+		{"power-on reset", nil, []reading{
+			{0, 85, 0, true},
+			{1, -127, 0, true},
+		}},
+		{"default range", nil, []reading{
+			{0, -56, 0, true},
+			{1, 126, 0, true},
+			{2, -20, -20, false},
+		}},
+		{"max only", &TempSensorConfig{MaxTemp: 10}, []reading{
+			{0, -18, -18, false},
+			{1, 11, 0, true},
+		}},
+		{"min only", &TempSensorConfig{MinTemp: -30}, []reading{
+			{0, 40, 40, false},
+			{1, -31, 0, true},
+		}},
+		{"spike rejected", nil, []reading{
+			{0, 20, 20, false},
+			{1, 45, 0, true},
+			{2, 21, 21, false},
+		}},
+		{"change over time", nil, []reading{
+			{0, 20, 20, false},
+			{5, 45, 45, false},
+		}},
+		{"persistent level accepted", nil, []reading{
+			{0, 20, 20, false},
+			{1, 45, 0, true},
+			{1.5, 45, 0, true},
+			{2, 45, 45, false},
+		}},
+		{"spike check disabled", &TempSensorConfig{MaxDeltaPerMinute: -1}, []reading{
+			{0, 20, 20, false},
+			{1, 45, 45, false},
+		}},
+		{"moving average", &TempSensorConfig{Average: 3}, []reading{
+			{0, 20, 20, false},
+			{1, 22, 21, false},
+			{2, 24, 22, false},
+			{3, 26, 24, false},
+		}},
+		{"average skips rejected", &TempSensorConfig{Average: 2}, []reading{
+			{0, 20, 20, false},
+			{1, 85, 0, true},
+			{2, 22, 21, false},
+		}},
+	}
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSensorFilter(tt.config)
+			for i, r := range tt.readings {
+				got, err := f.Apply(r.Raw, start.Add(time.Duration(r.Minute*float64(time.Minute))))
+				if (err != nil) != r.WantErr {
+					t.Fatalf("reading %d: got error %v, want error %v", i, err, r.WantErr)
+				}
+				if err == nil && math.Abs(got-r.Want) > 1e-9 {
+					t.Errorf("reading %d: got %.2f, want %.2f", i, got, r.Want)
+				}
+			}
+		})
+	}
+}
+
+func TestSensorFilterIsStale(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	f := newSensorFilter(nil)
+	f.LastValid = start
+	if _, err := f.Apply(20, start.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	f.Apply(85, start.Add(20*time.Minute))
+
+	tests := []struct {
+		minute int
+		want   bool
+	}{
+		{20, false},
+		{25, false},
+		{26, true},
+	}
+	for _, tt := range tests {
+		if got := f.IsStale(start.Add(time.Duration(tt.minute)*time.Minute), 15*time.Minute); got != tt.want {
+			t.Errorf("at minute %d: got stale %v, want %v", tt.minute, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -14,6 +15,9 @@ import (
 	"github.com/onatm/clockwerk"
 )
 
+// shutdownTimeout is the maximum time to wait for open requests when the server stops
+const shutdownTimeout = 10 * time.Second
+
 // Server defines the Garage web service
 type Server struct {
 	PortNo         int                  // Port number the server will listen on
@@ -22,12 +26,21 @@ type Server struct {
 	Finder         gopifinder.Finder    // Finder client - used to find other devices
 	Uploader       Thingspeak           // Cloud uploader
 	MqttClient     *Mqtt                // MQTT client
-	Room           *Room                // Room information
+	State          *StateStore          // Room state
 	RoomService    *RoomService         // Room service
 	NotifyService  NotifyService        // Notify service
+	DoorWatcher    *DoorWatcher         // Door state file watcher
+	History        *History             // Door event history
+	TempHistory    *TemperatureHistory  // Temperature history
+	AutoCloser     *AutoCloser          // Closes doors left open
+	Scheduler      *Scheduler           // User-defined schedules
+	Audit          *AuditLog            // Door actuation audit log
+	RateLimiter    RateLimiter          // Door command rate limiter
+	GuestPasses    *GuestPasses         // Guest access passes
 	exit           chan struct{}        // Exit flag
 	shutdown       chan struct{}        // Shutdown complete flag
 	http           *http.Server         // HTTP server
+	redirect       *http.Server         // HTTP to HTTPS redirect server
 	router         *mux.Router          // HTTP router
 	cw             *clockwerk.Clockwerk // Clockwerk scheduler
 	isregistering  bool                 // Indicates that a registration is currently ongoing
@@ -96,11 +109,14 @@ func (s *Server) run() {
 		s.RoomService.Srv = s
 	}
 
-	if s.Room == nil {
-		s.Room = &Room{}
+	if s.State == nil {
+		s.State = NewStateStore()
+	}
+	s.State.SetDoors(s.Config.Doors)
+
+	if err := s.RoomService.Initialize(); err != nil {
+		s.logError("Error initializing door sensors. ", err.Error())
 	}
-	s.Room.Door1Name = s.Config.Door1Name
-	s.Room.Door2Name = s.Config.Door2Name
 
 	if s.MqttClient == nil {
 		s.MqttClient = &Mqtt{}
@@ -108,8 +124,67 @@ func (s *Server) run() {
 	}
 
 	s.NotifyService.Srv = s
+	s.RateLimiter.Srv = s
+
+	if s.Audit == nil {
+		s.Audit = &AuditLog{}
+		s.Audit.Srv = s
+	}
+	if err := s.Audit.Open(); err != nil {
+		s.logError("Error opening audit log. Door commands will not be audited. ", err.Error())
+		s.Audit = nil
+	}
+
+	if s.Scheduler == nil {
+		s.Scheduler = &Scheduler{}
+		s.Scheduler.Srv = s
+	}
+
+	if s.AutoCloser == nil {
+		s.AutoCloser = &AutoCloser{}
+		s.AutoCloser.Srv = s
+	}
+
+	if s.DoorWatcher == nil {
+		s.DoorWatcher = &DoorWatcher{}
+		s.DoorWatcher.Srv = s
+	}
+
+	if s.History == nil {
+		s.History = &History{}
+		s.History.Srv = s
+	}
+	if err := s.History.Open(); err != nil {
+		s.logError("Error opening history database. History will not be recorded. ", err.Error())
+		s.History = nil
+	} else {
+		s.TempHistory = &TemperatureHistory{Srv: s}
+		if err := s.TempHistory.Open(s.History.db); err != nil {
+			s.logError("Error opening temperature history. Temperatures will not be recorded. ", err.Error())
+			s.TempHistory = nil
+		}
+		s.GuestPasses = &GuestPasses{Srv: s}
+		if err := s.GuestPasses.Open(s.History.db); err != nil {
+			s.logError("Error opening guest passes. Guest passes will not be available. ", err.Error())
+			s.GuestPasses = nil
+		}
+	}
 
 	s.logInfo("Configuration loaded successfully")
+	if !s.Config.Auth.Enabled {
+		s.logInfo("Authentication is disabled. Anyone on the network is granted the ", s.Config.Auth.AnonymousRole, " role.")
+	}
+
+	// Subscribe to the room state changes
+	s.Uploader.Start()
+	s.MqttClient.Start()
+	s.NotifyService.Start()
+	if s.Audit != nil {
+		s.Audit.Start()
+	}
+	if s.History != nil {
+		s.History.Start()
+	}
 
 	// Send initial telemetry
 	go func() {
@@ -117,6 +192,11 @@ func (s *Server) run() {
 		s.SendTelemetry()
 	}()
 
+	// Watch for door state changes
+	if err := s.DoorWatcher.Start(); err != nil {
+		s.logError("Error starting door watcher. Door state will only be updated on request. ", err.Error())
+	}
+
 	// Create a router
 	s.router = mux.NewRouter().StrictSlash(true)
 	s.router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./html/assets"))))
@@ -125,8 +205,16 @@ func (s *Server) run() {
 
 	// Add the controllers
 	s.addController(new(RoomController))
+	s.addController(new(SocketController))
 	s.addController(new(ConfigController))
 	s.addController(new(LogController))
+	s.addController(new(HistoryController))
+	s.addController(new(SensorController))
+	s.addController(new(AlarmController))
+	s.addController(new(ScheduleController))
+	s.addController(new(ReportController))
+	s.addController(new(AuditController))
+	s.addController(new(GuestController))
 
 	s.logInfo("Controllers loaded")
 
@@ -136,16 +224,9 @@ func (s *Server) run() {
 		Handler: s.router,
 	}
 
-	// Start the web server
-	go func() {
-		s.logInfo("Server listening on port ", s.PortNo)
-		if err := s.http.ListenAndServe(); err != nil {
-			msg := err.Error()
-			if !strings.Contains(msg, "http: Server closed") {
-				s.logError("Error starting Web Server. ", msg)
-			}
-		}
-	}()
+	if err := s.listen(); err != nil {
+		s.logError("Error loading the TLS certificate. Web methods will not be served. ", err.Error())
+	}
 
 	go func() {
 		// Register service with the Finder server
@@ -154,13 +235,45 @@ func (s *Server) run() {
 		// Start the scheduler
 		s.logInfo("Starting schedule")
 		s.startSchedule()
+		s.Scheduler.Start()
 	}()
 
 	// Wait for an exit signal
 	_ = <-s.exit
 
+	// Stop the state change subscriptions first, so that the event stream
+	// clients disconnect and do not hold up the HTTP server shutdown
+	s.State.Close()
+
 	// Shutdown the HTTP server
-	s.http.Shutdown(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := s.http.Shutdown(ctx); err != nil {
+		s.logError("Error shutting down Web Server. ", err.Error())
+	}
+	if s.redirect != nil {
+		s.redirect.Shutdown(ctx)
+		s.redirect = nil
+	}
+	cancel()
+
+	// Stop watching for door state changes
+	s.DoorWatcher.Close()
+
+	// Stop the user-defined schedules
+	s.Scheduler.Close()
+
+	// Release the door sensors
+	s.RoomService.Close()
+
+	// Close the history database
+	if s.History != nil {
+		s.History.Close()
+	}
+
+	// Close the audit log
+	if s.Audit != nil {
+		s.Audit.Close()
+	}
 
 	// Shutdown the MQTT client
 	s.MqttClient.Close()
@@ -169,6 +282,52 @@ func (s *Server) run() {
 	close(s.shutdown)
 }
 
+// listen starts the web server, and the HTTP to HTTPS redirect, in the background. Nothing is
+// served if TLS is enabled but the certificate cannot be loaded. HTTP is never used as a
+// fallback, as credentials would be sent in the clear.
+func (s *Server) listen() error {
+	if s.Config.TLS.Enabled {
+		tc, err := s.Config.TLS.ServerConfig()
+		if err != nil {
+			return err
+		}
+		s.http.TLSConfig = tc
+	}
+
+	// Start the web server
+	go func() {
+		var err error
+		if s.Config.TLS.Enabled {
+			s.logInfo("Server listening for HTTPS on port ", s.PortNo)
+			err = s.http.ListenAndServeTLS("", "")
+		} else {
+			s.logInfo("Server listening on port ", s.PortNo)
+			err = s.http.ListenAndServe()
+		}
+		if err != nil {
+			msg := err.Error()
+			if !strings.Contains(msg, "http: Server closed") {
+				s.logError("Error starting Web Server. ", msg)
+			}
+		}
+	}()
+
+	// Start the HTTP to HTTPS redirect
+	if s.Config.TLS.Enabled && s.Config.TLS.RedirectPort > 0 {
+		s.redirect = &http.Server{
+			Addr:    fmt.Sprintf(":%d", s.Config.TLS.RedirectPort),
+			Handler: s.Config.TLS.RedirectHandler(s.PortNo),
+		}
+		go func() {
+			s.logInfo("Redirecting HTTP on port ", s.Config.TLS.RedirectPort, " to HTTPS")
+			if err := s.redirect.ListenAndServe(); err != nil && !strings.Contains(err.Error(), "http: Server closed") {
+				s.logError("Error starting redirect server. ", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
 func (s *Server) startSchedule() {
 	if s.Config.Period <= 0 {
 		s.Config.Period = 5
@@ -180,6 +339,16 @@ func (s *Server) startSchedule() {
 	s.cw = clockwerk.New()
 	s.cw.Every(time.Duration(s.Config.Period) * time.Minute).Do(&s.Uploader)
 	s.cw.Every(time.Duration(1) * time.Minute).Do(&s.NotifyService)
+	s.cw.Every(time.Duration(1) * time.Minute).Do(s.AutoCloser)
+	if s.History != nil {
+		s.cw.Every(time.Duration(1) * time.Hour).Do(s.History)
+	}
+	if s.TempHistory != nil {
+		s.cw.Every(time.Duration(5) * time.Minute).Do(s.TempHistory)
+	}
+	if s.GuestPasses != nil {
+		s.cw.Every(time.Duration(1) * time.Hour).Do(s.GuestPasses)
+	}
 
 	s.cw.Start()
 
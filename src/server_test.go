@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freePort returns a local port that is not in use
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// isListening returns whether a connection to the local port can be made
+func isListening(port int) bool {
+	for i := 0; i < 20; i++ {
+		if c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+			c.Close()
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestServerListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bad := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(bad, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		tls      TLSConfig
+		wantErr  bool
+		wantServ bool
+	}{
+		{"http", TLSConfig{}, false, true},
+		{"https", TLSConfig{Enabled: true, CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}, false, true},
+		{"invalid certificate", TLSConfig{Enabled: true, CertFile: bad, KeyFile: bad}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, redirect := freePort(t), freePort(t)
+			tt.tls.RedirectPort = redirect
+			s := &Server{PortNo: port, Config: &Config{TLS: tt.tls}}
+			s.http = &http.Server{Addr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), Handler: http.NotFoundHandler()}
+			defer s.http.Close()
+
+			err := s.listen()
+			if s.redirect != nil {
+				defer s.redirect.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			// Nothing is served when the certificate cannot be loaded, rather than falling back to HTTP
+			if got := isListening(port); got != tt.wantServ {
+				t.Errorf("got listening %v, want %v", got, tt.wantServ)
+			}
+			if got := s.redirect != nil; got != (tt.wantServ && tt.tls.Enabled) {
+				t.Errorf("got redirect %v, want %v", got, tt.wantServ && tt.tls.Enabled)
+			}
+		})
+	}
+}
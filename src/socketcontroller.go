@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	socketWriteWait  = 10 * time.Second        // Time allowed to write a message
+	socketPongWait   = 60 * time.Second        // Time allowed to read the next pong
+	socketPingPeriod = socketPongWait * 9 / 10 // Interval at which pings are sent
+)
+
+// SocketController handles the WebSocket connections used by clients to receive the
+// room state and send door commands over a single connection.
+//
+// On connect the client receives a snapshot message, followed by an event message for
+// every change to the room. Door commands are sent as
+//
+//	{"id": "1", "type": "command", "door": 1, "action": "open"}
+//
+// and are acknowledged, once the door has finished moving, with an ack message carrying
+// the same id.
+type SocketController struct {
+	Srv      *Server
+	upgrader websocket.Upgrader
+}
+
+// SocketRequest is a message sent by a WebSocket client
+type SocketRequest struct {
+	ID     string `json:"id"`     // Request ID, returned in the acknowledgement
+	Type   string `json:"type"`   // Message type, only command is supported
+	DoorNo int    `json:"door"`   // Door number
+	Action string `json:"action"` // Door action: open, close or toggle
+}
+
+// SocketMessage is a message sent to a WebSocket client
+type SocketMessage struct {
+	Type    string      `json:"type"`              // Message type: snapshot, event or ack
+	ID      string      `json:"id,omitempty"`      // Request ID being acknowledged (ack)
+	Room    *Room       `json:"room,omitempty"`    // Room state (snapshot)
+	Event   *StateEvent `json:"event,omitempty"`   // State change (event)
+	Success bool        `json:"success,omitempty"` // Whether the request succeeded (ack)
+	Status  int         `json:"status,omitempty"`  // HTTP equivalent status of the result (ack)
+	Error   string      `json:"error,omitempty"`   // Reason the request failed (ack)
+}
+
+// AddController adds the controller routes to the router
+func (c *SocketController) AddController(router *mux.Router, s *Server) {
+	c.Srv = s
+	router.Methods("GET").Path("/room/ws").Name("WebSocket").
+		Handler(Authorize(c, s, RoleViewer, Logger(c, http.HandlerFunc(c.handleSocket))))
+}
+
+// handleSocket upgrades the connection and serves the client until it disconnects
+func (c *SocketController) handleSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		c.LogError("Error upgrading connection. ", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	sub := c.Srv.State.Subscribe()
+	defer sub.Close()
+
+	send := make(chan SocketMessage, 16)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Writer
+	go func() {
+		ping := time.NewTicker(socketPingPeriod)
+		defer ping.Stop()
+		for {
+			var err error
+			select {
+			case m := <-send:
+				conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+				err = conn.WriteJSON(m)
+			case e, ok := <-sub.C:
+				if !ok {
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+						time.Now().Add(socketWriteWait))
+					conn.Close()
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+				err = conn.WriteJSON(SocketMessage{Type: "event", Event: &e})
+			case <-ping.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+			case <-done:
+				return
+			}
+			if err != nil {
+				c.LogError("Error writing to ", r.RemoteAddr, ". ", err.Error())
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	room := c.Srv.State.Snapshot()
+	send <- SocketMessage{Type: "snapshot", Room: &room}
+
+	// Reader
+	conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+	for {
+		req := SocketRequest{}
+		if err := conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.LogError("Error reading from ", r.RemoteAddr, ". ", err.Error())
+			}
+			return
+		}
+		if req.Type != "command" {
+			c.reply(send, done, SocketMessage{Type: "ack", ID: req.ID, Status: http.StatusBadRequest, Error: "unknown message type"})
+			continue
+		}
+		if p := RequestPrincipal(r); !p.Role.Allows(RoleOperator) {
+			c.LogInfo("Forbidden door ", req.DoorNo, " ", req.Action, " command from ", p.Name, " (", p.Role, ") at ", r.RemoteAddr)
+			c.reply(send, done, SocketMessage{Type: "ack", ID: req.ID, Status: http.StatusForbidden, Error: "operator role required"})
+			continue
+		}
+		if ok, _ := c.Srv.RateLimiter.Allow(clientKey(r)); !ok {
+			c.LogInfo("Rate limit exceeded by ", clientKey(r), " for door ", req.DoorNo, " ", req.Action, " command")
+			c.reply(send, done, SocketMessage{Type: "ack", ID: req.ID, Status: http.StatusTooManyRequests, Error: ErrRateLimited.Error()})
+			continue
+		}
+		c.LogInfo("Door ", req.DoorNo, " ", req.Action, " command from ", r.RemoteAddr)
+		// Run the command in the background as it waits for the door to finish moving
+		go func(req SocketRequest) {
+			o := CommandOrigin{Source: SourceWebSocket, RemoteAddr: r.RemoteAddr, Principal: RequestPrincipal(r).Name}
+			err := c.Srv.RoomService.DoorCommand(req.DoorNo, req.Action, o)
+			m := SocketMessage{Type: "ack", ID: req.ID, Success: err == nil, Status: commandStatus(err)}
+			if err != nil {
+				m.Error = err.Error()
+			}
+			c.reply(send, done, m)
+		}(req)
+	}
+}
+
+// reply queues the message for the writer unless the connection has closed
+func (c *SocketController) reply(send chan<- SocketMessage, done <-chan struct{}, m SocketMessage) {
+	select {
+	case send <- m:
+	case <-done:
+	}
+}
+
+// LogInfo is used to log information messages for this controller.
+func (c *SocketController) LogInfo(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Info("SocketController: [Inf] ", a)
+}
+
+// LogError is used to log error messages for this controller.
+func (c *SocketController) LogError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("SocketController: [Err] ", a)
+}
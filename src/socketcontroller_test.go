@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// dialTestSocket starts a server for the socket controller and connects to it
+func dialTestSocket(t *testing.T, s *Server) (*websocket.Conn, func()) {
+	router := mux.NewRouter()
+	c := &SocketController{}
+	c.AddController(router, s)
+	ts := httptest.NewServer(router)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/room/ws", nil)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		ts.Close()
+	}
+}
+
+// readSocket returns the next message of the specified type, skipping any others
+func readSocket(t *testing.T, conn *websocket.Conn, msgType string) SocketMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		m := SocketMessage{}
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type == msgType {
+			return m
+		}
+	}
+}
+
+func TestSocketSnapshot(t *testing.T) {
+	r, _, _ := newTestRoomService(true, false)
+	conn, done := dialTestSocket(t, r.Srv)
+	defer done()
+
+	m := readSocket(t, conn, "snapshot")
+	if m.Room == nil || len(m.Room.Doors) != 1 || m.Room.Doors[0].ID != 1 {
+		t.Errorf("got snapshot %+v, want the room with door 1", m.Room)
+	}
+}
+
+func TestSocketCommands(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         SocketRequest
+		wantSuccess bool
+		wantStatus  int
+	}{
+		{"open", SocketRequest{ID: "1", Type: "command", DoorNo: 1, Action: "open"}, true, http.StatusNoContent},
+		{"already closed", SocketRequest{ID: "2", Type: "command", DoorNo: 1, Action: "close"}, false, http.StatusConflict},
+		{"unknown door", SocketRequest{ID: "3", Type: "command", DoorNo: 2, Action: "open"}, false, http.StatusNotFound},
+		{"unknown action", SocketRequest{ID: "4", Type: "command", DoorNo: 1, Action: "lock"}, false, http.StatusBadRequest},
+		{"unknown type", SocketRequest{ID: "5", Type: "subscribe"}, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, relay := newTestRoomService(true, false)
+			r.Srv.Config.Auth.AnonymousRole = RoleOperator
+			conn, done := dialTestSocket(t, r.Srv)
+			defer done()
+			readSocket(t, conn, "snapshot")
+
+			if err := conn.WriteJSON(tt.req); err != nil {
+				t.Fatal(err)
+			}
+			m := readSocket(t, conn, "ack")
+			if m.ID != tt.req.ID || m.Success != tt.wantSuccess || m.Status != tt.wantStatus {
+				t.Errorf("got ack %s success %v status %d (%s), want ack %s success %v status %d",
+					m.ID, m.Success, m.Status, m.Error, tt.req.ID, tt.wantSuccess, tt.wantStatus)
+			}
+			if wantPulses := map[bool]int{true: 1, false: 0}[tt.wantSuccess]; relay.Pulses != wantPulses {
+				t.Errorf("got %d pulses, want %d", relay.Pulses, wantPulses)
+			}
+		})
+	}
+}
+
+func TestSocketCommandViewer(t *testing.T) {
+	r, _, relay := newTestRoomService(true, false)
+	conn, done := dialTestSocket(t, r.Srv)
+	defer done()
+	readSocket(t, conn, "snapshot")
+
+	// Anonymous callers are only granted the viewer role by default
+	if err := conn.WriteJSON(SocketRequest{ID: "1", Type: "command", DoorNo: 1, Action: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := readSocket(t, conn, "ack"); m.Success || m.Status != http.StatusForbidden {
+		t.Errorf("got ack success %v status %d, want status %d", m.Success, m.Status, http.StatusForbidden)
+	}
+	if relay.Pulses != 0 {
+		t.Errorf("got %d pulses, want 0", relay.Pulses)
+	}
+}
+
+func TestSocketEvents(t *testing.T) {
+	r, _, _ := newTestRoomService(true, false)
+	conn, done := dialTestSocket(t, r.Srv)
+	defer done()
+	readSocket(t, conn, "snapshot")
+
+	r.Srv.State.UpdateDoor(1, func(d *DoorState) { d.Closed = !d.Closed })
+	m := readSocket(t, conn, "event")
+	if m.Event == nil || m.Event.Type != EventDoor || m.Event.Door == nil || m.Event.Door.ID != 1 {
+		t.Errorf("got event %+v, want a door 1 event", m.Event)
+	}
+}
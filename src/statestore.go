@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StateEventType identifies the kind of change made to the room state
+type StateEventType string
+
+// Room state change event types
+const (
+	EventDoor        StateEventType = "door"        // A door changed state
+	EventTemperature StateEventType = "temperature" // The room temperature changed
+	EventCommand     StateEventType = "command"     // A door command completed
+	EventAlarm       StateEventType = "alarm"       // An alarm was raised
+)
+
+// stateHistorySize is the number of recent events kept so that subscribers can resume
+const stateHistorySize = 100
+
+// StateEvent describes a change made to the room state
+type StateEvent struct {
+	ID          uint64         `json:"id"`                // Sequence number of the event
+	Type        StateEventType `json:"type"`              // Type of change
+	Time        time.Time      `json:"time"`              // Time the change was made
+	Door        *DoorState     `json:"door,omitempty"`    // New door state (door events)
+	Command     *CommandResult `json:"command,omitempty"` // Command result (command events)
+	Alarm       *Alarm         `json:"alarm,omitempty"`   // Alarm raised (alarm events)
+	Sensor      string         `json:"sensor,omitempty"`  // Name of the sensor that changed, empty for the room temperature (temperature events)
+	Reading     *SensorReading `json:"reading,omitempty"` // New sensor reading (sensor temperature events)
+	Temperature float64        `json:"temp"`              // Room temperature, or the sensor temperature
+}
+
+// CommandResult holds the outcome of a door command
+type CommandResult struct {
+	DoorNo  int           `json:"door"`            // Door number
+	Action  string        `json:"action"`          // Action requested, i.e. open, close or toggle
+	Origin  CommandOrigin `json:"origin"`          // Where the command came from
+	Success bool          `json:"success"`         // Whether the command succeeded
+	Error   string        `json:"error,omitempty"` // Reason the command failed
+}
+
+// Alarm holds the details of an alarm raised for a door or temperature sensor
+type Alarm struct {
+	DoorNo  int    `json:"door"`             // Door number, 0 if the alarm is not for a specific door
+	Sensor  string `json:"sensor,omitempty"` // Temperature sensor name (sensor alarms)
+	Message string `json:"message"`          // Alarm message
+}
+
+// Subscription receives the change events published by a StateStore
+type Subscription struct {
+	C     <-chan StateEvent // Channel the events are delivered on
+	c     chan StateEvent
+	store *StateStore
+}
+
+// Close stops delivery of events and closes the channel
+func (s *Subscription) Close() {
+	s.store.unsubscribe(s)
+}
+
+// StateStore owns the room state. All changes are made under a lock, readers get
+// an immutable snapshot and consumers can subscribe to the changes.
+type StateStore struct {
+	room    Room
+	seq     uint64
+	history []StateEvent
+	subs    map[*Subscription]bool
+	closed  bool
+	mu      sync.RWMutex
+}
+
+// NewStateStore creates an empty state store
+func NewStateStore() *StateStore {
+	return &StateStore{subs: make(map[*Subscription]bool)}
+}
+
+// Snapshot returns a copy of the current room state
+func (s *StateStore) Snapshot() Room {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.copyRoom()
+}
+
+// Door returns a copy of the state of the specified door, and whether it exists
+func (s *StateStore) Door(id int) (DoorState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if d := s.room.Door(id); d != nil {
+		return *d, true
+	}
+	return DoorState{}, false
+}
+
+// SetDoors synchronizes the doors with the configured doors
+func (s *StateStore) SetDoors(doors []DoorConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room.SetDoors(doors)
+}
+
+// UpdateDoor applies the update function to the specified door. A door event is
+// published if the door changed. Returns the new door state and whether it changed.
+func (s *StateStore) UpdateDoor(id int, update func(d *DoorState)) (DoorState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.room.Door(id)
+	if d == nil {
+		return DoorState{}, false
+	}
+	old := *d
+	update(d)
+	if *d == old {
+		return *d, false
+	}
+	n := *d
+	s.publish(StateEvent{Type: EventDoor, Door: &n, Temperature: s.room.Temperature})
+	return n, true
+}
+
+// SetTemperature sets the room temperature and read time. A temperature
+// event is published if the temperature changed.
+func (s *StateStore) SetTemperature(temp float64, read time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room.LastRead = read
+	if s.room.Temperature == temp {
+		return
+	}
+	s.room.Temperature = temp
+	s.publish(StateEvent{Type: EventTemperature, Temperature: temp})
+}
+
+// SetSensor sets the reading of a temperature sensor. A temperature event is
+// published if the temperature, stale flag or error of the sensor changed.
+func (s *StateStore) SetSensor(r SensorReading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.room.Sensors == nil {
+		s.room.Sensors = make(map[string]SensorReading)
+	}
+	// Remove the reading held under the previous name of a renamed sensor
+	for k, v := range s.room.Sensors {
+		if v.ID == r.ID && k != r.Name {
+			delete(s.room.Sensors, k)
+		}
+	}
+	old, ok := s.room.Sensors[r.Name]
+	s.room.Sensors[r.Name] = r
+	if ok && old.Temperature == r.Temperature && old.Stale == r.Stale && old.Error == r.Error {
+		return
+	}
+	s.publish(StateEvent{Type: EventTemperature, Sensor: r.Name, Reading: &r, Temperature: r.Temperature})
+}
+
+// SetLastRead sets the time the room values were last read
+func (s *StateStore) SetLastRead(read time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room.LastRead = read
+}
+
+// PublishCommand publishes the result of a door command
+func (s *StateStore) PublishCommand(doorNo int, action string, o CommandOrigin, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := CommandResult{DoorNo: doorNo, Action: action, Origin: o, Success: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	s.publish(StateEvent{Type: EventCommand, Command: &c, Temperature: s.room.Temperature})
+}
+
+// PublishAlarm publishes an alarm raised for a door
+func (s *StateStore) PublishAlarm(doorNo int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(StateEvent{Type: EventAlarm, Alarm: &Alarm{DoorNo: doorNo, Message: message}, Temperature: s.room.Temperature})
+}
+
+// PublishSensorAlarm publishes an alarm raised for a temperature sensor
+func (s *StateStore) PublishSensorAlarm(sensor string, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(StateEvent{Type: EventAlarm, Alarm: &Alarm{Sensor: sensor, Message: message}, Temperature: s.room.Temperature})
+}
+
+// Subscribe returns a subscription that receives all subsequent change events.
+// Events are dropped if the subscriber does not keep up.
+func (s *StateStore) Subscribe() *Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscribe()
+}
+
+// SubscribeFrom returns a subscription along with the recent events published after the
+// specified event id. The returned bool is false if events have been missed because the
+// id is older than the events that are kept, in which case the caller should start from
+// a snapshot.
+func (s *StateStore) SubscribeFrom(lastID uint64) (*Subscription, []StateEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	missed := []StateEvent{}
+	complete := lastID <= s.seq
+	if len(s.history) != 0 && s.history[0].ID > lastID+1 {
+		complete = false
+	}
+	for _, e := range s.history {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return s.subscribe(), missed, complete
+}
+
+// subscribe creates a subscription. Must be called with the lock held.
+func (s *StateStore) subscribe() *Subscription {
+	c := make(chan StateEvent, 32)
+	sub := &Subscription{C: c, c: c, store: s}
+	if s.closed {
+		close(c)
+		return sub
+	}
+	s.subs[sub] = true
+	return sub
+}
+
+// Close closes all subscriptions
+func (s *StateStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subs {
+		close(sub.c)
+	}
+	s.subs = make(map[*Subscription]bool)
+	s.closed = true
+}
+
+// unsubscribe removes the subscription and closes its channel
+func (s *StateStore) unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[sub] {
+		delete(s.subs, sub)
+		close(sub.c)
+	}
+}
+
+// publish sends the event to the subscribers. Must be called with the lock held.
+func (s *StateStore) publish(e StateEvent) {
+	s.seq++
+	e.ID = s.seq
+	e.Time = time.Now()
+	s.history = append(s.history, e)
+	if len(s.history) > stateHistorySize {
+		s.history = s.history[len(s.history)-stateHistorySize:]
+	}
+	for sub := range s.subs {
+		select {
+		case sub.c <- e:
+		default:
+			s.logError("Subscriber is not keeping up. Dropped ", e.Type, " event ", e.ID)
+		}
+	}
+}
+
+// copyRoom returns a copy of the room. Must be called with the lock held.
+func (s *StateStore) copyRoom() Room {
+	r := s.room
+	r.Doors = append([]DoorState{}, s.room.Doors...)
+	r.Sensors = make(map[string]SensorReading, len(s.room.Sensors))
+	for k, v := range s.room.Sensors {
+		r.Sensors[k] = v
+	}
+	return r
+}
+
+// logError logs an error message to the logger
+func (s *StateStore) logError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("StateStore: [Err] ", a)
+}
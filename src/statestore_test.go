@@ -0,0 +1,193 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestStateStore creates a state store with two enabled doors
+func newTestStateStore() *StateStore {
+	s := NewStateStore()
+	s.SetDoors([]DoorConfig{{ID: 1, Name: "Left", Enabled: true}, {ID: 2, Name: "Right", Enabled: true}})
+	return s
+}
+
+// receive returns the next event of the subscription, failing the test if there is none
+func receive(t *testing.T, sub *Subscription) StateEvent {
+	t.Helper()
+	select {
+	case e, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return StateEvent{}
+}
+
+func TestStateStorePublish(t *testing.T) {
+	read := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		change    func(s *StateStore)
+		wantEvent bool
+		wantType  StateEventType
+	}{
+		{"door changed", func(s *StateStore) {
+			s.UpdateDoor(1, func(d *DoorState) { d.Closed = true })
+		}, true, EventDoor},
+		{"door unchanged", func(s *StateStore) {
+			s.UpdateDoor(1, func(d *DoorState) {})
+		}, false, ""},
+		{"unknown door", func(s *StateStore) {
+			s.UpdateDoor(3, func(d *DoorState) { d.Closed = true })
+		}, false, ""},
+		{"temperature changed", func(s *StateStore) {
+			s.SetTemperature(21.5, read)
+		}, true, EventTemperature},
+		{"temperature unchanged", func(s *StateStore) {
+			s.SetTemperature(0, read)
+		}, false, ""},
+		{"sensor added", func(s *StateStore) {
+			s.SetSensor(SensorReading{ID: "28-1", Name: "Freezer", Temperature: -18})
+		}, true, EventTemperature},
+		{"command", func(s *StateStore) {
+			s.PublishCommand(1, "open", CommandOrigin{Source: SourceRest}, nil)
+		}, true, EventCommand},
+		{"alarm", func(s *StateStore) {
+			s.PublishAlarm(1, "Left's door has been open for 5 minutes.")
+		}, true, EventAlarm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStateStore()
+			sub := s.Subscribe()
+			defer sub.Close()
+			tt.change(s)
+			select {
+			case e := <-sub.C:
+				if !tt.wantEvent {
+					t.Fatalf("got unexpected %s event", e.Type)
+				}
+				if e.Type != tt.wantType || e.ID != 1 {
+					t.Errorf("got %s event %d, want %s event 1", e.Type, e.ID, tt.wantType)
+				}
+			default:
+				if tt.wantEvent {
+					t.Fatal("no event published")
+				}
+			}
+		})
+	}
+}
+
+func TestStateStoreSnapshotIsCopy(t *testing.T) {
+	s := newTestStateStore()
+	s.SetSensor(SensorReading{ID: "28-1", Name: "Freezer", Temperature: -18})
+	r := s.Snapshot()
+	r.Doors[0].Closed = true
+	r.Sensors["Freezer"] = SensorReading{Temperature: 5}
+
+	r = s.Snapshot()
+	if r.Doors[0].Closed || r.Sensors["Freezer"].Temperature != -18 {
+		t.Error("changing a snapshot changed the room state")
+	}
+}
+
+func TestStateStoreClose(t *testing.T) {
+	s := newTestStateStore()
+	sub := s.Subscribe()
+	s.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription not closed")
+	}
+	sub.Close()
+
+	// Subscriptions made after the store is closed are closed at once
+	if _, ok := <-s.Subscribe().C; ok {
+		t.Error("subscription after close not closed")
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	s := newTestStateStore()
+	sub := s.Subscribe()
+	other := s.Subscribe()
+	defer other.Close()
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription not closed")
+	}
+	s.PublishAlarm(1, "alarm")
+	if e := receive(t, other); e.Type != EventAlarm {
+		t.Errorf("got %s event, want %s", e.Type, EventAlarm)
+	}
+}
+
+func TestStateStoreSubscribeFrom(t *testing.T) {
+	tests := []struct {
+		name         string
+		published    int    // Number of events published before resuming
+		lastID       uint64 // Last event id received by the client
+		wantFirst    uint64 // Id of the first missed event, 0 for none
+		wantMissed   int
+		wantComplete bool
+	}{
+		{"nothing published", 0, 0, 0, 0, true},
+		{"up to date", 3, 3, 0, 0, true},
+		{"resume", 3, 1, 2, 2, true},
+		{"resume from start", 3, 0, 1, 3, true},
+		{"id from before a restart", 3, 10, 0, 0, false},
+		{"oldest kept", stateHistorySize + 5, 5, 6, stateHistorySize, true},
+		{"older than kept", stateHistorySize + 5, 4, 6, stateHistorySize, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStateStore()
+			for i := 0; i < tt.published; i++ {
+				s.PublishAlarm(1, "alarm")
+			}
+			sub, missed, complete := s.SubscribeFrom(tt.lastID)
+			defer sub.Close()
+			if len(missed) != tt.wantMissed || complete != tt.wantComplete {
+				t.Fatalf("got %d missed events and complete %v, want %d and %v", len(missed), complete, tt.wantMissed, tt.wantComplete)
+			}
+			if len(missed) != 0 && missed[0].ID != tt.wantFirst {
+				t.Errorf("got first missed event %d, want %d", missed[0].ID, tt.wantFirst)
+			}
+			for i := 1; i < len(missed); i++ {
+				if missed[i].ID != missed[i-1].ID+1 {
+					t.Fatalf("missed events out of sequence at %d", missed[i].ID)
+				}
+			}
+
+			// Events published after resuming are delivered on the subscription
+			s.PublishAlarm(2, "alarm")
+			if e := receive(t, sub); e.ID != uint64(tt.published+1) {
+				t.Errorf("got event %d, want %d", e.ID, tt.published+1)
+			}
+		})
+	}
+}
+
+func TestStateStoreSensorRename(t *testing.T) {
+	s := newTestStateStore()
+	s.SetSensor(SensorReading{ID: "28-1", Name: "28-1", Temperature: -18})
+	sub := s.Subscribe()
+	defer sub.Close()
+
+	// The reading moves to the new name and is published under it
+	s.SetSensor(SensorReading{ID: "28-1", Name: "Freezer", Named: true, Temperature: -18})
+	if e := receive(t, sub); e.Type != EventTemperature || e.Sensor != "Freezer" {
+		t.Errorf("got %s event for sensor %s, want %s event for Freezer", e.Type, e.Sensor, EventTemperature)
+	}
+	r := s.Snapshot()
+	if _, ok := r.Sensors["28-1"]; ok {
+		t.Error("reading under the previous name not removed")
+	}
+	if rd, ok := r.Sensors["Freezer"]; !ok || !rd.Named || rd.Temperature != -18 {
+		t.Errorf("got reading %+v, want the named reading", rd)
+	}
+}
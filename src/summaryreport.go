@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary report periods
+const (
+	ReportDay  = "day"  // The last 24 hours
+	ReportWeek = "week" // The last 7 days
+)
+
+// ErrInvalidPeriod is returned when a summary report is requested for an unknown period
+var ErrInvalidPeriod = errors.New("period must be day or week")
+
+// SummaryReport summarizes the door activity, temperatures and alarms over a period
+type SummaryReport struct {
+	Period       string               `json:"period"` // Report period: day or week
+	From         time.Time            `json:"from"`   // Start of the period
+	To           time.Time            `json:"to"`     // End of the period
+	Doors        []DoorSummary        `json:"doors"`  // Activity of each door
+	Temperatures []TemperatureSummary `json:"temps"`  // Temperatures of each named sensor
+	Alarms       []HistoryEvent       `json:"alarms"` // Alarms raised, oldest first
+}
+
+// DoorSummary summarizes the activity of a door over the report period
+type DoorSummary struct {
+	DoorNo      int       `json:"door"`                  // Door number
+	Name        string    `json:"name"`                  // Door name
+	Opens       int       `json:"opens"`                 // Number of times the door was opened
+	OpenTime    int       `json:"openTime"`              // Total time (in seconds) the door was open
+	LongestOpen int       `json:"longestOpen"`           // Longest time (in seconds) the door was open
+	LongestFrom time.Time `json:"longestFrom,omitempty"` // Time the longest open period started
+}
+
+// TemperatureSummary summarizes the temperatures of a sensor over the report period
+type TemperatureSummary struct {
+	Sensor string  `json:"sensor"` // Sensor name
+	Min    float64 `json:"min"`    // Lowest temperature
+	Max    float64 `json:"max"`    // Highest temperature
+	Avg    float64 `json:"avg"`    // Average temperature
+	Count  int     `json:"count"`  // Number of readings
+}
+
+// NewSummaryReport builds the summary report for the period ending at the specified time
+// from the recorded door and temperature history
+func NewSummaryReport(s *Server, period string, to time.Time) (SummaryReport, error) {
+	r := SummaryReport{Period: period, To: to, Doors: []DoorSummary{}, Temperatures: []TemperatureSummary{}, Alarms: []HistoryEvent{}}
+	switch period {
+	case ReportDay:
+		r.From = to.Add(-24 * time.Hour)
+	case ReportWeek:
+		r.From = to.Add(-7 * 24 * time.Hour)
+	default:
+		return r, ErrInvalidPeriod
+	}
+	if s.History == nil {
+		return r, errors.New("history is not being recorded")
+	}
+
+	evts, err := s.History.Query(HistoryQuery{From: r.From, To: r.To})
+	if err != nil {
+		return r, err
+	}
+	// Events are returned most recent first
+	for i := len(evts) - 1; i >= 0; i-- {
+		if evts[i].Type == HistoryAlarm {
+			r.Alarms = append(r.Alarms, evts[i])
+		}
+	}
+
+	for _, dc := range s.Config.Doors {
+		if !dc.Enabled {
+			continue
+		}
+		ds, err := r.summarizeDoor(s.History, dc, evts)
+		if err != nil {
+			return r, err
+		}
+		r.Doors = append(r.Doors, ds)
+	}
+
+	if s.TempHistory != nil {
+		for _, sc := range s.Config.TempSensors {
+			pts, err := s.TempHistory.Query(sc.Name, r.From, r.To, 0)
+			if err != nil {
+				return r, err
+			}
+			if ts, ok := summarizeSensor(sc.Name, pts); ok {
+				r.Temperatures = append(r.Temperatures, ts)
+			}
+		}
+	}
+	return r, nil
+}
+
+// summarizeDoor works out the open periods of the door from its transitions. The door state at
+// the start of the period is taken from the last transition recorded before it. Unknown states
+// are skipped, and only a door that moves off closed to opening or open is counted as opened,
+// so a sensor that drops out does not count as an open.
+func (r *SummaryReport) summarizeDoor(h *History, dc DoorConfig, evts []HistoryEvent) (DoorSummary, error) {
+	ds := DoorSummary{DoorNo: dc.ID, Name: dc.Name}
+
+	var openFrom time.Time
+	last := DoorClosed
+	prev, err := h.Query(HistoryQuery{DoorNo: dc.ID, To: r.From, Limit: 50})
+	if err != nil {
+		return ds, err
+	}
+	for _, e := range prev {
+		if e.Type == HistoryTransition && e.State != DoorUnknown {
+			last = e.State
+			if e.State != DoorClosed {
+				openFrom = r.From
+			}
+			break
+		}
+	}
+
+	closeAt := func(t time.Time) {
+		d := int(t.Sub(openFrom).Seconds())
+		ds.OpenTime += d
+		if d > ds.LongestOpen {
+			ds.LongestOpen = d
+			ds.LongestFrom = openFrom
+		}
+		openFrom = time.Time{}
+	}
+	for i := len(evts) - 1; i >= 0; i-- {
+		e := evts[i]
+		if e.Type != HistoryTransition || e.DoorNo != dc.ID || e.State == DoorUnknown {
+			continue
+		}
+		switch {
+		case e.State == DoorClosed && !openFrom.IsZero():
+			closeAt(e.Time)
+		case e.State != DoorClosed && openFrom.IsZero():
+			openFrom = e.Time
+			if last == DoorClosed && (e.State == DoorOpening || e.State == DoorOpen) {
+				ds.Opens++
+			}
+		}
+		last = e.State
+	}
+	if !openFrom.IsZero() {
+		closeAt(r.To)
+	}
+	return ds, nil
+}
+
+// summarizeSensor combines the temperature points of a sensor. Returns false if there are no readings.
+func summarizeSensor(sensor string, pts []TemperaturePoint) (TemperatureSummary, bool) {
+	ts := TemperatureSummary{Sensor: sensor, Min: math.Inf(1), Max: math.Inf(-1)}
+	sum := 0.0
+	for _, p := range pts {
+		n := p.Count
+		if n <= 0 {
+			n = 1
+		}
+		ts.Min = math.Min(ts.Min, p.Min)
+		ts.Max = math.Max(ts.Max, p.Max)
+		sum += p.Avg * float64(n)
+		ts.Count += n
+	}
+	if ts.Count == 0 {
+		return ts, false
+	}
+	ts.Avg = sum / float64(ts.Count)
+	return ts, true
+}
+
+// Text returns the report as a message
+func (r *SummaryReport) Text(loc *time.Location) string {
+	title := "Daily"
+	if r.Period == ReportWeek {
+		title = "Weekly"
+	}
+	lines := []string{fmt.Sprintf("%s garage summary to %s.", title, r.To.In(loc).Format("Mon 2 Jan 15:04"))}
+	for _, d := range r.Doors {
+		l := fmt.Sprintf("%s: opened %d times, open for %s", d.Name, d.Opens, formatMinutes(d.OpenTime))
+		if d.LongestOpen > 0 {
+			l += fmt.Sprintf(", longest %s from %s", formatMinutes(d.LongestOpen), d.LongestFrom.In(loc).Format("Mon 15:04"))
+		}
+		lines = append(lines, l+".")
+	}
+	for _, t := range r.Temperatures {
+		lines = append(lines, fmt.Sprintf("%s: min %.1f°C, max %.1f°C, avg %.1f°C.", t.Sensor, t.Min, t.Max, t.Avg))
+	}
+	if len(r.Alarms) == 0 {
+		lines = append(lines, "No alarms.")
+	} else {
+		lines = append(lines, fmt.Sprintf("%d alarms:", len(r.Alarms)))
+		counts := map[string]int{}
+		msgs := []string{}
+		for _, a := range r.Alarms {
+			if counts[a.Message] == 0 {
+				msgs = append(msgs, a.Message)
+			}
+			counts[a.Message]++
+		}
+		sort.SliceStable(msgs, func(i, j int) bool { return counts[msgs[i]] > counts[msgs[j]] })
+		for _, m := range msgs {
+			if counts[m] > 1 {
+				m = fmt.Sprintf("%s (x%d)", m, counts[m])
+			}
+			lines = append(lines, "- "+m)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatMinutes formats a number of seconds as hours and minutes
+func formatMinutes(secs int) string {
+	m := secs / 60
+	if m < 60 {
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dh%02dm", m/60, m%60)
+}
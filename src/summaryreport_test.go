@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummaryReportDoors(t *testing.T) {
+	to := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	// transition is a transition of the door the specified number of hours before the end of the report
+	type transition struct {
+		Hours float64
+		State DoorStatus
+	}
+	week := []transition{
+		{-200, DoorClosed},
+		{-100, DoorOpening},
+		{-99.5, DoorOpen},
+		{-99, DoorClosing},
+		{-98.5, DoorClosed},
+		{-30, DoorOpening},
+		{-28, DoorClosed},
+		{-5, DoorUnknown},
+		{-4, DoorClosed},
+		{-3, DoorOpen},
+		{-2, DoorClosed},
+	}
+	tests := []struct {
+		name        string
+		period      string
+		transitions []transition
+		wantOpens   int
+		wantTime    time.Duration
+		wantLongest time.Duration
+	}{
+		{"open and close", ReportDay, []transition{{-30, DoorClosed}, {-10, DoorOpening}, {-9.5, DoorOpen}, {-8, DoorClosed}}, 1, 2 * time.Hour, 2 * time.Hour},
+		{"unknown is not an open", ReportDay, []transition{{-30, DoorClosed}, {-10, DoorUnknown}, {-9, DoorClosed}}, 0, 0, 0},
+		{"unknown before opening", ReportDay, []transition{{-30, DoorClosed}, {-10, DoorUnknown}, {-9, DoorOpen}, {-8, DoorClosed}}, 1, time.Hour, time.Hour},
+		{"unknown while open", ReportDay, []transition{{-30, DoorClosed}, {-10, DoorOpening}, {-9, DoorUnknown}, {-8, DoorClosed}}, 1, 2 * time.Hour, 2 * time.Hour},
+		{"stopped and reversed", ReportDay, []transition{{-10, DoorOpening}, {-9.75, DoorStopped}, {-9.5, DoorClosing}, {-9.25, DoorOpening}, {-8, DoorClosed}}, 1, 2 * time.Hour, 2 * time.Hour},
+		{"open before the period", ReportDay, []transition{{-30, DoorOpen}, {-20, DoorClosed}}, 0, 4 * time.Hour, 4 * time.Hour},
+		{"unknown before the period", ReportDay, []transition{{-30, DoorOpen}, {-25, DoorUnknown}, {-20, DoorClosed}}, 0, 4 * time.Hour, 4 * time.Hour},
+		{"open at the end", ReportDay, []transition{{-2, DoorOpening}}, 1, 2 * time.Hour, 2 * time.Hour},
+		{"day", ReportDay, week, 1, time.Hour, time.Hour},
+		{"week", ReportWeek, week, 3, 4*time.Hour + 30*time.Minute, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, done := openTestHistory(t)
+			defer done()
+			// The test history holds events for doors 1 and 2
+			s := &Server{Config: &Config{Doors: []DoorConfig{{ID: 3, Name: "Bob", Enabled: true}}}, History: h}
+			for _, tr := range tt.transitions {
+				e := HistoryEvent{Time: to.Add(time.Duration(tr.Hours * float64(time.Hour))), Type: HistoryTransition, DoorNo: 3, State: tr.State}
+				if err := h.Record(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := NewSummaryReport(s, tt.period, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Doors) != 1 {
+				t.Fatalf("got %d doors, want 1", len(r.Doors))
+			}
+			d := r.Doors[0]
+			if d.Opens != tt.wantOpens || d.OpenTime != int(tt.wantTime.Seconds()) || d.LongestOpen != int(tt.wantLongest.Seconds()) {
+				t.Errorf("got %d opens, open for %ds, longest %ds, want %d opens, open for %ds, longest %ds",
+					d.Opens, d.OpenTime, d.LongestOpen, tt.wantOpens, int(tt.wantTime.Seconds()), int(tt.wantLongest.Seconds()))
+			}
+		})
+	}
+}
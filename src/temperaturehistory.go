@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TemperatureSeries describes one resolution of the stored temperature readings
+type TemperatureSeries struct {
+	Bucket     []byte        // Bolt bucket the points are stored in
+	Resolution time.Duration // Time covered by each point, 0 for raw readings
+	Retention  time.Duration // Time the points are kept for
+}
+
+// The temperature series, from the finest to the coarsest resolution
+var temperatureSeries = []TemperatureSeries{
+	{Bucket: []byte("temperature-raw"), Resolution: 0, Retention: 48 * time.Hour},
+	{Bucket: []byte("temperature-5m"), Resolution: 5 * time.Minute, Retention: 30 * 24 * time.Hour},
+	{Bucket: []byte("temperature-1h"), Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+}
+
+// TemperaturePoint is a temperature reading, or the summary of the readings over a period
+type TemperaturePoint struct {
+	Time  time.Time `json:"time"`  // Time of the reading, or start of the period
+	Avg   float64   `json:"avg"`   // Average temperature
+	Min   float64   `json:"min"`   // Minimum temperature
+	Max   float64   `json:"max"`   // Maximum temperature
+	Count int       `json:"count"` // Number of readings
+}
+
+// TemperatureHistory stores every temperature reading and rolls them up into
+// 5 minute averages and hourly summaries. Each series bucket holds a bucket per sensor.
+type TemperatureHistory struct {
+	Srv *Server  // Server instance
+	db  *bolt.DB // Database, shared with the door event history
+}
+
+// Open creates the temperature buckets in the database
+func (t *TemperatureHistory) Open(db *bolt.DB) error {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, s := range temperatureSeries {
+			if _, err := tx.CreateBucketIfNotExists(s.Bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	t.db = db
+	return nil
+}
+
+// Record stores a temperature reading for the named sensor
+func (t *TemperatureHistory) Record(sensor string, temp float64, at time.Time) error {
+	p := TemperaturePoint{Time: at.UTC(), Avg: temp, Min: temp, Max: temp, Count: 1}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return t.db.Update(func(tx *bolt.Tx) error {
+		for _, s := range temperatureSeries {
+			if _, err := tx.Bucket(s.Bucket).CreateBucketIfNotExists([]byte(sensor)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(temperatureSeries[0].Bucket).Bucket([]byte(sensor)).Put(temperatureKey(p.Time), b)
+	})
+}
+
+// Run is called from the scheduler (ClockWerk). This function rolls up the completed
+// periods into the coarser series and removes the points that are past their retention.
+func (t *TemperatureHistory) Run() {
+	now := time.Now().UTC()
+	if err := t.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(temperatureSeries[0].Bucket).ForEach(func(k, v []byte) error {
+			if v != nil {
+				// Not a sensor bucket
+				return nil
+			}
+			for i := 1; i < len(temperatureSeries); i++ {
+				if err := t.rollup(tx, k, temperatureSeries[i-1], temperatureSeries[i], now); err != nil {
+					return err
+				}
+			}
+			for _, s := range temperatureSeries {
+				if err := t.prune(tx, k, s, now.Add(-s.Retention)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		t.logError("Error rolling up temperature history. ", err.Error())
+	}
+}
+
+// Query returns the temperature points of the named sensor between the two times. The
+// finest series that still covers the from time is used. If step is greater than the
+// resolution of the series, the points are summarized into periods of that length.
+func (t *TemperatureHistory) Query(sensor string, from time.Time, to time.Time, step time.Duration) ([]TemperaturePoint, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+	s := temperatureSeries[len(temperatureSeries)-1]
+	for _, ts := range temperatureSeries {
+		if time.Since(from) <= ts.Retention {
+			s = ts
+			break
+		}
+	}
+
+	pts := []TemperaturePoint{}
+	err := t.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.Bucket).Bucket([]byte(sensor))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		end := temperatureKey(to)
+		for k, v := c.Seek(temperatureKey(from)); k != nil && string(k) <= string(end); k, v = c.Next() {
+			p := TemperaturePoint{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			pts = append(pts, p)
+		}
+		return nil
+	})
+	if err != nil || step <= s.Resolution {
+		return pts, err
+	}
+	return summarizeTemperatures(pts, step), nil
+}
+
+// rollup summarizes the completed periods of the source series of the sensor into the destination series
+func (t *TemperatureHistory) rollup(tx *bolt.Tx, sensor []byte, src TemperatureSeries, dst TemperatureSeries, now time.Time) error {
+	sb := tx.Bucket(src.Bucket).Bucket(sensor)
+	db := tx.Bucket(dst.Bucket).Bucket(sensor)
+
+	// Start after the last period already rolled up
+	start := time.Time{}
+	if k, _ := db.Cursor().Last(); k != nil {
+		start = temperatureTime(k).Add(dst.Resolution)
+	}
+	end := now.Truncate(dst.Resolution)
+
+	pts := []TemperaturePoint{}
+	c := sb.Cursor()
+	for k, v := c.Seek(temperatureKey(start)); k != nil && temperatureTime(k).Before(end); k, v = c.Next() {
+		p := TemperaturePoint{}
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		pts = append(pts, p)
+	}
+	for _, p := range summarizeTemperatures(pts, dst.Resolution) {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if err := db.Put(temperatureKey(p.Time), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune removes the points of the series of the sensor recorded before the specified time
+func (t *TemperatureHistory) prune(tx *bolt.Tx, sensor []byte, s TemperatureSeries, before time.Time) error {
+	c := tx.Bucket(s.Bucket).Bucket(sensor).Cursor()
+	end := temperatureKey(before)
+	for k, _ := c.First(); k != nil && string(k) < string(end); k, _ = c.Next() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarizeTemperatures groups the points, which must be in time order, into periods of the specified length
+func summarizeTemperatures(pts []TemperaturePoint, period time.Duration) []TemperaturePoint {
+	lst := []TemperaturePoint{}
+	for _, p := range pts {
+		pt := p.Time.Truncate(period)
+		if n := len(lst); n != 0 && lst[n-1].Time.Equal(pt) {
+			s := &lst[n-1]
+			s.Avg = (s.Avg*float64(s.Count) + p.Avg*float64(p.Count)) / float64(s.Count+p.Count)
+			s.Count += p.Count
+			if p.Min < s.Min {
+				s.Min = p.Min
+			}
+			if p.Max > s.Max {
+				s.Max = p.Max
+			}
+			continue
+		}
+		p.Time = pt
+		lst = append(lst, p)
+	}
+	return lst
+}
+
+// temperatureKey builds the database key for a point. Keys sort in time order.
+func temperatureKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	ns := int64(0)
+	if !t.IsZero() {
+		ns = t.UnixNano()
+	}
+	binary.BigEndian.PutUint64(k, uint64(ns))
+	return k
+}
+
+// temperatureTime returns the time held in a database key
+func temperatureTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k))).UTC()
+}
+
+// logError logs an error message to the logger
+func (t *TemperatureHistory) logError(v ...interface{}) {
+	a := fmt.Sprint(v...)
+	logger.Error("TemperatureHistory: [Err] ", a)
+}
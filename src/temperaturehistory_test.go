@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestTemperatureHistory opens a temperature history in a temporary database
+func openTestTemperatureHistory(t *testing.T) (*TemperatureHistory, func()) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "history.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	h := &TemperatureHistory{}
+	if err := h.Open(db); err != nil {
+		t.Fatal(err)
+	}
+	return h, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSummarizeTemperatures(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	pt := func(offset time.Duration, temp float64) TemperaturePoint {
+		return TemperaturePoint{Time: t0.Add(offset), Avg: temp, Min: temp, Max: temp, Count: 1}
+	}
+	tests := []struct {
+		name string
+		pts  []TemperaturePoint
+		want []TemperaturePoint
+	}{
+		{"empty", nil, []TemperaturePoint{}},
+		{"single period", []TemperaturePoint{pt(0, 20), pt(time.Minute, 22), pt(4*time.Minute+59*time.Second, 24)},
+			[]TemperaturePoint{{Time: t0, Avg: 22, Min: 20, Max: 24, Count: 3}}},
+		{"period boundary", []TemperaturePoint{pt(4*time.Minute+59*time.Second, 20), pt(5*time.Minute, 30)},
+			[]TemperaturePoint{{Time: t0, Avg: 20, Min: 20, Max: 20, Count: 1}, {Time: t0.Add(5 * time.Minute), Avg: 30, Min: 30, Max: 30, Count: 1}}},
+		{"weighted by count", []TemperaturePoint{{Time: t0, Avg: 10, Min: 8, Max: 12, Count: 3}, {Time: t0.Add(time.Minute), Avg: 30, Min: 30, Max: 30, Count: 1}},
+			[]TemperaturePoint{{Time: t0, Avg: 15, Min: 8, Max: 30, Count: 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeTemperatures(tt.pts, 5*time.Minute)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d points, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !got[i].Time.Equal(tt.want[i].Time) || got[i].Avg != tt.want[i].Avg || got[i].Min != tt.want[i].Min ||
+					got[i].Max != tt.want[i].Max || got[i].Count != tt.want[i].Count {
+					t.Errorf("point %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTemperatureRollup(t *testing.T) {
+	h, done := openTestTemperatureHistory(t)
+	defer done()
+
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, r := range []struct {
+		offset time.Duration
+		temp   float64
+	}{
+		{0, 20},
+		{4*time.Minute + 59*time.Second, 22},
+		{5 * time.Minute, 30},
+		{9*time.Minute + 59*time.Second, 32},
+		{10 * time.Minute, 40},
+	} {
+		if err := h.Record("Freezer", r.temp, t0.Add(r.offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each step rolls up at the time, and checks the 5 minute points held afterwards
+	steps := []struct {
+		now  time.Duration
+		want []TemperaturePoint
+	}{
+		{4 * time.Minute, []TemperaturePoint{}},
+		{7 * time.Minute, []TemperaturePoint{{Time: t0, Avg: 21, Count: 2}}},
+		{10 * time.Minute, []TemperaturePoint{{Time: t0, Avg: 21, Count: 2}, {Time: t0.Add(5 * time.Minute), Avg: 31, Count: 2}}},
+		{12 * time.Minute, []TemperaturePoint{{Time: t0, Avg: 21, Count: 2}, {Time: t0.Add(5 * time.Minute), Avg: 31, Count: 2}}},
+	}
+	for _, s := range steps {
+		if err := h.db.Update(func(tx *bolt.Tx) error {
+			return h.rollup(tx, []byte("Freezer"), temperatureSeries[0], temperatureSeries[1], t0.Add(s.now))
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		got := []TemperaturePoint{}
+		h.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(temperatureSeries[1].Bucket).Bucket([]byte("Freezer")).ForEach(func(k, v []byte) error {
+				p := TemperaturePoint{}
+				json.Unmarshal(v, &p)
+				got = append(got, p)
+				return nil
+			})
+		})
+		if len(got) != len(s.want) {
+			t.Fatalf("at %v: got %d points, want %d", s.now, len(got), len(s.want))
+		}
+		for i := range got {
+			if !got[i].Time.Equal(s.want[i].Time) || got[i].Avg != s.want[i].Avg || got[i].Count != s.want[i].Count {
+				t.Errorf("at %v: point %d: got %+v, want %+v", s.now, i, got[i], s.want[i])
+			}
+		}
+	}
+}
@@ -3,93 +3,149 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// thingspeakMinInterval is the minimum time allowed by Thingspeak between updates
+const thingspeakMinInterval = 15 * time.Second
+
+// thingspeakTimeout is the maximum time an upload to Thingspeak may take
+const thingspeakTimeout = 10 * time.Second
+
 // Thingspeak uploads the room telemetry to the Thingspeak server in the cloud
 type Thingspeak struct {
-	Srv               *Server   // Current Server
-	LastUpdateAttempt time.Time // Last time an update was attempted
-	LastUpdate        time.Time // Last time the update was run
-	lastValues        *Room     // Last values uploaded for Room
+	Srv               *Server     // Current Server
+	LastUpdateAttempt time.Time   // Last time an update was attempted
+	LastUpdate        time.Time   // Last time the update was run
+	timer             *time.Timer // Pending upload of a change
+	uploading         bool        // Whether an upload is in progress
+	mu                sync.Mutex  // Protects the upload state
+}
+
+// Start subscribes to the room state changes so that changes are uploaded as they happen
+func (t *Thingspeak) Start() {
+	sub := t.Srv.State.Subscribe()
+	go func() {
+		for range sub.C {
+			t.requestUpload()
+		}
+	}()
 }
 
 // Run is called from the scheduler (ClockWerk). This function will get the latest measurements
-// and send the measurements to Thingspeak
+// and send the measurements to Thingspeak if they have not been sent within the update period.
 func (t *Thingspeak) Run() {
 	if err := t.Srv.RoomService.UpdateTelemetry(); err != nil {
 		t.logError("Error updating telemetry. ", err.Error())
 	}
 
-	uploadThingspeak := true
-	key := ""
+	t.mu.Lock()
+	mustUpload := time.Since(t.LastUpdate) >= time.Duration(t.Srv.Config.Period)*time.Minute
+	t.mu.Unlock()
+	if mustUpload {
+		t.upload()
+	}
+
+	// Send MQTT Telemetry
+	t.Srv.MqttClient.SendTelemetry()
+}
+
+// requestUpload uploads the telemetry as soon as Thingspeak allows
+func (t *Thingspeak) requestUpload() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		// An upload is already pending
+		return
+	}
+	delay := thingspeakMinInterval - time.Since(t.LastUpdateAttempt)
+	if delay < 0 {
+		delay = 0
+	}
+	t.timer = time.AfterFunc(delay, func() {
+		t.mu.Lock()
+		t.timer = nil
+		t.mu.Unlock()
+		t.upload()
+	})
+}
+
+// upload sends the current room telemetry to Thingspeak
+func (t *Thingspeak) upload() {
 	if !t.Srv.Config.EnableThingspeak {
 		t.logInfo("Thingspeak has been disabled")
-		uploadThingspeak = false
+		return
 	}
-	if uploadThingspeak {
-		key = t.Srv.Config.ThingspeakID
-		if key == "" {
-			t.logError("Thingspeak API ID has not been configured")
-			uploadThingspeak = false
-		}
+	key := t.Srv.Config.ThingspeakID
+	if key == "" {
+		t.logError("Thingspeak API ID has not been configured")
+		return
 	}
 
-	mustUpload := false
-	if t.lastValues == nil {
-		t.lastValues = &Room{}
-		mustUpload = true
-	} else {
-		if time.Since(t.LastUpdate) >= time.Duration(t.Srv.Config.Period)*time.Minute {
-			// Time since last update exceeds the required
-			mustUpload = true
-		} else {
-			// Check for changes
-			if t.lastValues.Door1Closed != t.Srv.Room.Door1Closed || t.lastValues.Door2Closed != t.Srv.Room.Door2Closed || t.lastValues.Temperature != t.Srv.Room.Temperature {
-				mustUpload = true
-			}
-		}
+	t.mu.Lock()
+	if t.uploading {
+		// Upload the latest telemetry once the current upload is done
+		t.mu.Unlock()
+		t.requestUpload()
+		return
 	}
+	t.uploading = true
+	t.LastUpdateAttempt = time.Now()
+	t.mu.Unlock()
 
-	if mustUpload && uploadThingspeak {
-		t.logInfo("Uploading telemetry")
-		t.LastUpdateAttempt = time.Now()
-		client := http.Client{}
-		door1Closed := 0
-		if t.Srv.Room.Door1Closed {
-			door1Closed = 1
+	t.logInfo("Uploading telemetry")
+	room := t.Srv.State.Snapshot()
+	fields := map[int]string{}
+	setField := func(f int, v string, name string) {
+		if f <= 0 {
+			return
 		}
-		door2Closed := 0
-		if t.Srv.Room.Door2Closed {
-			door2Closed = 1
+		if _, ok := fields[f]; ok {
+			t.logError("Thingspeak field ", f, " of ", name, " is already used. It will not be uploaded.")
+			return
 		}
-		url := fmt.Sprintf("https://api.thingspeak.com/update?api_key=%s&field1=%d&field2=%d&field3=%d&field4=%f",
-			key,
-			door1Closed,
-			door2Closed,
-			1,
-			t.Srv.Room.Temperature)
-		if resp, err := client.Get(url); err != nil {
-			t.logError("Error sending telemetry to Thingspeak. ", err.Error())
-		} else {
-			if resp.StatusCode != 200 {
-				t.logError("Error sending telemetry to Thingspeak. Status ", resp.StatusCode, "returned.")
-			}
+		fields[f] = v
+	}
+	setField(t.Srv.Config.ThingspeakPingField, "1", "the heartbeat")
+	setField(t.Srv.Config.ThingspeakTempField, fmt.Sprintf("%f", room.Temperature), "the room temperature")
+	for _, d := range room.Doors {
+		dc := t.Srv.Config.Door(d.ID)
+		if dc == nil {
+			continue
+		}
+		closed := "0"
+		if d.Closed {
+			closed = "1"
+		}
+		setField(dc.ThingspeakField, closed, dc.Name)
+	}
+	for _, sc := range t.Srv.Config.TempSensors {
+		if sr, ok := room.Sensors[sc.Name]; ok {
+			setField(sc.ThingspeakField, fmt.Sprintf("%f", sr.Temperature), sc.Name)
+		}
+	}
+	url := "https://api.thingspeak.com/update?api_key=" + key
+	for f := 1; f <= 8; f++ {
+		if v, ok := fields[f]; ok {
+			url += fmt.Sprintf("&field%d=%s", f, v)
+		}
+	}
+	// The request is made without the lock held, so a slow server does not block the upload requests
+	client := http.Client{Timeout: thingspeakTimeout}
+	if resp, err := client.Get(url); err != nil {
+		t.logError("Error sending telemetry to Thingspeak. ", err.Error())
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.logError("Error sending telemetry to Thingspeak. Status ", resp.StatusCode, "returned.")
 		}
-
-		// Update last values
-		t.lastValues.Door1Name = t.Srv.Room.Door1Name
-		t.lastValues.Door1Closed = t.Srv.Room.Door1Closed
-		t.lastValues.Door2Name = t.Srv.Room.Door2Name
-		t.lastValues.Door2Closed = t.Srv.Room.Door2Closed
-		t.lastValues.Temperature = t.Srv.Room.Temperature
-		t.lastValues.LastRead = t.Srv.Room.LastRead
-
-		t.LastUpdate = time.Now()
 	}
 
-	// Send MQTT Telemetry
-	t.Srv.MqttClient.SendTelemetry()
+	t.mu.Lock()
+	t.uploading = false
+	t.LastUpdate = time.Now()
+	t.mu.Unlock()
 }
 
 // logInfo logs an information message to the logger
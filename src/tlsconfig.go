@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSConfig holds the HTTPS settings of the web server
+type TLSConfig struct {
+	Enabled           bool   `json:"enabled"`           // Serve HTTPS instead of HTTP
+	CertFile          string `json:"certFile"`          // Certificate file (PEM), defaults to data/cert.pem. A self-signed certificate is generated if it does not exist.
+	KeyFile           string `json:"keyFile"`           // Private key file (PEM), defaults to data/key.pem
+	ClientCAFile      string `json:"clientCAFile"`      // CA certificates (PEM) used to verify client certificates, empty to not request client certificates
+	RequireClientCert bool   `json:"requireClientCert"` // Refuse connections without a valid client certificate
+	RedirectPort      int    `json:"redirectPort"`      // Port of a plain HTTP listener that redirects to HTTPS (0 for no redirect)
+}
+
+// SetDefaults sets the default certificate and key paths
+func (c *TLSConfig) SetDefaults() {
+	if c.CertFile == "" {
+		c.CertFile = filepath.Join("data", "cert.pem")
+	}
+	if c.KeyFile == "" {
+		c.KeyFile = filepath.Join("data", "key.pem")
+	}
+}
+
+// ServerConfig returns the TLS configuration of the web server, generating a
+// self-signed certificate if the certificate file does not exist
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if _, err := os.Stat(c.CertFile); os.IsNotExist(err) {
+		if err := c.generateCertificate(); err != nil {
+			return nil, fmt.Errorf("error generating self-signed certificate. %s", err.Error())
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("no certificates found in " + c.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tc, nil
+}
+
+// generateCertificate creates a self-signed certificate for the host name and addresses of this device
+func (c *TLSConfig) generateCertificate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"Garage"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host != "" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host, host+".local")
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, n.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.CertFile), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.KeyFile), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// RedirectHandler returns a handler that redirects requests to the HTTPS port
+func (c *TLSConfig) RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = net.JoinHostPort(host, fmt.Sprint(httpsPort))
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
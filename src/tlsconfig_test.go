@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSServerConfigGeneratesCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := TLSConfig{CertFile: filepath.Join(dir, "tls", "cert.pem"), KeyFile: filepath.Join(dir, "tls", "key.pem")}
+	tc, err := c.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tc.Certificates) != 1 || tc.MinVersion != tls.VersionTLS12 || tc.ClientAuth != tls.NoClientCert {
+		t.Errorf("got %d certificates, min version %x and client auth %v", len(tc.Certificates), tc.MinVersion, tc.ClientAuth)
+	}
+	if fi, err := os.Stat(c.KeyFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("key file not written owner-only. %v", err)
+	}
+
+	b, err := ioutil.ReadFile(c.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		t.Fatal("certificate file is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+
+	// The existing certificate is used rather than generating a new one
+	if _, err := c.ServerConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if b2, _ := ioutil.ReadFile(c.CertFile); string(b2) != string(b) {
+		t.Error("certificate regenerated")
+	}
+}
+
+func TestTLSServerConfigClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "garage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The generated certificate doubles as the client CA
+	c := TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	if _, err := c.ServerConfig(); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, []byte("no certificates"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		caFile   string
+		require  bool
+		wantAuth tls.ClientAuthType
+		wantErr  bool
+	}{
+		{"optional", c.CertFile, false, tls.VerifyClientCertIfGiven, false},
+		{"required", c.CertFile, true, tls.RequireAndVerifyClientCert, false},
+		{"missing file", filepath.Join(dir, "missing.pem"), false, 0, true},
+		{"no certificates", empty, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := c
+			cc.ClientCAFile = tt.caFile
+			cc.RequireClientCert = tt.require
+			tc, err := cc.ServerConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (tc.ClientAuth != tt.wantAuth || tc.ClientCAs == nil) {
+				t.Errorf("got client auth %v, want %v", tc.ClientAuth, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestTLSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"host and port", "http://garage.local:8080/room/get", "https://garage.local:8443/room/get"},
+		{"host only", "http://garage.local/", "https://garage.local:8443/"},
+		{"query", "http://192.168.1.5:8080/history?door=1", "https://192.168.1.5:8443/history?door=1"},
+	}
+	c := TLSConfig{}
+	h := c.RedirectHandler(8443)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", tt.url, nil))
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.want {
+				t.Errorf("got %d to %s, want %d to %s", w.Code, w.Header().Get("Location"), http.StatusPermanentRedirect, tt.want)
+			}
+		})
+	}
+}